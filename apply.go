@@ -0,0 +1,150 @@
+package kubicle
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/restmapper"
+	"sigs.k8s.io/kustomize/api/krusty"
+	"sigs.k8s.io/kustomize/kyaml/filesys"
+)
+
+// fieldManager identifies kubicle as the owner of fields it applies.
+const fieldManager = "kubicle"
+
+// ApplyManifest server-side applies every object in a (possibly multi-document)
+// YAML or JSON manifest. Objects are applied in order, so a manifest may create
+// a namespace before the objects that live in it. Namespaced objects without a
+// namespace are applied to the default namespace. Re-applying a manifest is
+// idempotent.
+func (c *Cluster) ApplyManifest(ctx context.Context, manifest []byte) error {
+	objs, err := decodeManifest(manifest)
+	if err != nil {
+		return err
+	}
+
+	dc, err := c.DynamicClient()
+	if err != nil {
+		return err
+	}
+	mapper := restmapper.NewDeferredDiscoveryRESTMapper(memory.NewMemCacheClient(c.Discovery()))
+
+	for _, obj := range objs {
+		if err := applyObject(ctx, dc, mapper, obj); err != nil {
+			return fmt.Errorf("failed to apply %s %q: %w", obj.GetKind(), obj.GetName(), err)
+		}
+	}
+	return nil
+}
+
+// ApplyManifestFile reads the manifest at path and applies it like ApplyManifest.
+func (c *Cluster) ApplyManifestFile(ctx context.Context, path string) error {
+	manifest, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read manifest: %w", err)
+	}
+	return c.ApplyManifest(ctx, manifest)
+}
+
+// ApplyKustomize renders the kustomization in dir, like kubectl kustomize,
+// and applies the result like ApplyManifest. Bases, components and patches
+// are resolved relative to dir; remote bases are fetched with git.
+func (c *Cluster) ApplyKustomize(ctx context.Context, dir string) error {
+	kustomizer := krusty.MakeKustomizer(krusty.MakeDefaultOptions())
+	resources, err := kustomizer.Run(filesys.MakeFsOnDisk(), dir)
+	if err != nil {
+		return fmt.Errorf("failed to render kustomization %s: %w", dir, err)
+	}
+	manifest, err := resources.AsYaml()
+	if err != nil {
+		return fmt.Errorf("failed to encode kustomization %s: %w", dir, err)
+	}
+	return c.ApplyManifest(ctx, manifest)
+}
+
+func decodeManifest(manifest []byte) ([]*unstructured.Unstructured, error) {
+	decoder := yaml.NewYAMLOrJSONDecoder(bytes.NewReader(manifest), 4096)
+
+	var objs []*unstructured.Unstructured
+	for {
+		obj := &unstructured.Unstructured{}
+		err := decoder.Decode(&obj.Object)
+		if err == io.EOF {
+			return objs, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode manifest: %w", err)
+		}
+		// Empty documents, e.g. from a trailing "---", decode to nothing.
+		if len(obj.Object) == 0 {
+			continue
+		}
+		objs = append(objs, obj)
+	}
+}
+
+func applyObject(ctx context.Context, dc dynamic.Interface, mapper *restmapper.DeferredDiscoveryRESTMapper, obj *unstructured.Unstructured) error {
+	gvk := obj.GroupVersionKind()
+	mapping, err := mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if meta.IsNoMatchError(err) {
+		// The kind may have been registered by an earlier object, such as a
+		// CustomResourceDefinition, so refresh discovery and try again.
+		mapper.Reset()
+		mapping, err = mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to map kind: %w", err)
+	}
+
+	var resource dynamic.ResourceInterface = dc.Resource(mapping.Resource)
+	if mapping.Scope.Name() == meta.RESTScopeNameNamespace {
+		namespace := obj.GetNamespace()
+		if namespace == "" {
+			namespace = metav1.NamespaceDefault
+		}
+		resource = dc.Resource(mapping.Resource).Namespace(namespace)
+	}
+
+	if obj.GetName() == "" {
+		return errors.New("object has no name")
+	}
+	_, err = resource.Apply(ctx, obj.GetName(), obj, metav1.ApplyOptions{
+		FieldManager: fieldManager,
+		Force:        true,
+	})
+	return err
+}
+
+// fetchManifest downloads a manifest, such as an add-on's release manifest.
+func fetchManifest(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create manifest request: %w", err)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download manifest %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to download manifest %s: %s", url, resp.Status)
+	}
+	manifest, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest %s: %w", url, err)
+	}
+	return manifest, nil
+}
@@ -0,0 +1,130 @@
+package kubicle
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/distribution/reference"
+	"github.com/docker/cli/cli/config"
+	"github.com/docker/docker/api/types/registry"
+)
+
+// dockerHubAuthAddress is the server address Docker uses for Docker Hub
+// credentials.
+const dockerHubAuthAddress = "https://index.docker.io/v1/"
+
+// RegistryAuth holds the credentials for a single container registry.
+type RegistryAuth struct {
+	// ServerAddress is the registry host the credentials are for, e.g.
+	// "ghcr.io" or "localhost:5000". An empty address means Docker Hub.
+	ServerAddress string
+	Username      string
+	Password      string
+	// IdentityToken is used instead of a username and password by registries
+	// that issue OAuth refresh tokens.
+	IdentityToken string
+}
+
+func (a RegistryAuth) authConfig() registry.AuthConfig {
+	return registry.AuthConfig{
+		Username:      a.Username,
+		Password:      a.Password,
+		IdentityToken: a.IdentityToken,
+		ServerAddress: a.ServerAddress,
+	}
+}
+
+// normalizeRegistryHost maps the different spellings of a registry address to
+// the bare host name, so credentials can be matched against image references.
+func normalizeRegistryHost(address string) string {
+	address = strings.TrimPrefix(address, "https://")
+	address = strings.TrimPrefix(address, "http://")
+	address = strings.TrimSuffix(address, "/")
+	address = strings.TrimSuffix(address, "/v1")
+	address = strings.TrimSuffix(address, "/v2")
+
+	switch address {
+	case "", "docker.io", "index.docker.io", "registry-1.docker.io":
+		return "docker.io"
+	}
+	return address
+}
+
+// imageRegistryHost returns the registry host an image reference resolves to.
+func imageRegistryHost(imageName string) (string, error) {
+	named, err := reference.ParseNormalizedNamed(imageName)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse image reference %q: %w", imageName, err)
+	}
+	return normalizeRegistryHost(reference.Domain(named)), nil
+}
+
+// encodeAuthForImage returns the encoded credentials from auths that apply to
+// the registry imageName lives in, or encoded empty credentials if none do.
+func encodeAuthForImage(imageName string, auths []RegistryAuth) (string, error) {
+	host, err := imageRegistryHost(imageName)
+	if err != nil {
+		return "", err
+	}
+
+	var config registry.AuthConfig
+	for _, auth := range auths {
+		if normalizeRegistryHost(auth.ServerAddress) == host {
+			config = auth.authConfig()
+			break
+		}
+	}
+
+	encoded, err := registry.EncodeAuthConfig(config)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode registry credentials: %w", err)
+	}
+	return encoded, nil
+}
+
+// buildAuthConfigs returns auths keyed the way the build API expects, so the
+// daemon can pick the right credentials for each base image it pulls.
+func buildAuthConfigs(auths []RegistryAuth) map[string]registry.AuthConfig {
+	if len(auths) == 0 {
+		return nil
+	}
+
+	configs := make(map[string]registry.AuthConfig, len(auths))
+	for _, auth := range auths {
+		key := normalizeRegistryHost(auth.ServerAddress)
+		if key == "docker.io" {
+			key = dockerHubAuthAddress
+		}
+		configs[key] = auth.authConfig()
+	}
+	return configs
+}
+
+// dockerConfigAuths returns the credentials the docker CLI would use, read
+// from config.json in $DOCKER_CONFIG or ~/.docker, including those held by
+// credential helpers. Like the docker CLI, it carries on without credentials
+// if they cannot be read.
+func dockerConfigAuths() []RegistryAuth {
+	configFile, err := config.Load(config.Dir())
+	if err != nil {
+		return nil
+	}
+	credentials, err := configFile.GetAllCredentials()
+	if err != nil {
+		return nil
+	}
+
+	auths := make([]RegistryAuth, 0, len(credentials))
+	for address, credential := range credentials {
+		if credential.ServerAddress != "" {
+			address = credential.ServerAddress
+		}
+		auths = append(auths, RegistryAuth{
+			ServerAddress: address,
+			Username:      credential.Username,
+			Password:      credential.Password,
+			IdentityToken: credential.IdentityToken,
+		})
+	}
+	return auths
+}
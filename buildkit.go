@@ -0,0 +1,133 @@
+package kubicle
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+
+	"github.com/docker/cli/cli/config/configfile"
+	clitypes "github.com/docker/cli/cli/config/types"
+	"github.com/docker/docker/api/types/registry"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/jsonmessage"
+	controlapi "github.com/moby/buildkit/api/services/control"
+	"github.com/moby/buildkit/session"
+	"github.com/moby/buildkit/session/auth/authprovider"
+	"github.com/moby/buildkit/session/secrets/secretsprovider"
+	"google.golang.org/protobuf/proto"
+)
+
+// BuildSecret is a secret made available to RUN --mount=type=secret,id=<ID>
+// instructions of a BuildKit build. Secrets are mounted while the instruction
+// runs and are not stored in any layer.
+type BuildSecret struct {
+	ID string
+	// Path is a file on the host holding the secret.
+	Path string
+	// Value is the secret itself. It is used if Path is empty.
+	Value []byte
+}
+
+// buildKitTraceID is the ID of messages in a BuildKit build's message stream
+// that carry the build's progress as a protobuf encoded StatusResponse.
+const buildKitTraceID = "moby.buildkit.trace"
+
+// startBuildSession starts a BuildKit session serving secrets and registry
+// credentials to the builds that reference its ID. BuildKit asks the session
+// for credentials rather than using the build's AuthConfigs, so auths must be
+// served here too. The session runs until it is closed.
+func startBuildSession(ctx context.Context, cli *client.Client, name string, secrets []BuildSecret, auths map[string]registry.AuthConfig) (*session.Session, error) {
+	values := make(map[string][]byte, len(secrets))
+	for _, secret := range secrets {
+		if secret.ID == "" {
+			return nil, fmt.Errorf("build secret ID must not be empty")
+		}
+		value := secret.Value
+		if secret.Path != "" {
+			var err error
+			value, err = os.ReadFile(secret.Path)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read build secret %s: %w", secret.ID, err)
+			}
+		}
+		values[secret.ID] = value
+	}
+
+	s, err := session.NewSession(ctx, name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create build session: %w", err)
+	}
+	s.Allow(secretsprovider.FromMap(values))
+	s.Allow(buildAuthProvider(auths))
+
+	go func() {
+		// The daemon calls back into the session over a hijacked
+		// connection. Run returns once the session is closed.
+		_ = s.Run(ctx, func(ctx context.Context, proto string, meta map[string][]string) (net.Conn, error) {
+			return cli.DialHijack(ctx, "/session", proto, meta)
+		})
+	}()
+	return s, nil
+}
+
+// buildAuthProvider returns a session attachable that answers BuildKit's
+// credential requests from auths, keyed like buildAuthConfigs keys them.
+func buildAuthProvider(auths map[string]registry.AuthConfig) session.Attachable {
+	// A config file without a credentials store only looks credentials up in
+	// AuthConfigs and is never written.
+	configFile := configfile.New("")
+	for key, auth := range auths {
+		configFile.AuthConfigs[key] = clitypes.AuthConfig{
+			Username:      auth.Username,
+			Password:      auth.Password,
+			ServerAddress: auth.ServerAddress,
+			IdentityToken: auth.IdentityToken,
+		}
+	}
+	return authprovider.NewDockerAuthProvider(authprovider.DockerAuthProviderConfig{
+		ConfigFile: configFile,
+	})
+}
+
+// writeBuildKitLogs writes the output of build steps carried by a BuildKit
+// trace message to w. Other messages are ignored.
+func writeBuildKitLogs(w io.Writer, msg jsonmessage.JSONMessage) error {
+	if msg.ID != buildKitTraceID || msg.Aux == nil {
+		return nil
+	}
+
+	var data []byte
+	if err := json.Unmarshal(*msg.Aux, &data); err != nil {
+		return fmt.Errorf("failed to decode build trace: %w", err)
+	}
+	var status controlapi.StatusResponse
+	if err := proto.Unmarshal(data, &status); err != nil {
+		return fmt.Errorf("failed to decode build trace: %w", err)
+	}
+
+	// Each step is reported again as its state changes, so only name it
+	// once, when it starts or turns out to be cached.
+	for _, vertex := range status.Vertexes {
+		var line string
+		switch {
+		case vertex.Cached && vertex.Completed != nil:
+			line = "CACHED " + vertex.Name
+		case vertex.Started != nil && vertex.Completed == nil:
+			line = vertex.Name
+		default:
+			continue
+		}
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	for _, log := range status.Logs {
+		if _, err := w.Write(log.Msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
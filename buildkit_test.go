@@ -0,0 +1,87 @@
+package kubicle
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"testing/fstest"
+
+	"github.com/moby/buildkit/session/auth"
+)
+
+// requireDocker skips the test if the Docker daemon is not reachable.
+func requireDocker(t *testing.T) {
+	t.Helper()
+	if testing.Short() {
+		t.Skip("skipping Docker test in short mode")
+	}
+	if err := pingDocker(context.Background()); err != nil {
+		t.Skipf("Docker is not available: %v", err)
+	}
+}
+
+func TestBuildAuthProvider(t *testing.T) {
+	provider := buildAuthProvider(buildAuthConfigs([]RegistryAuth{
+		{ServerAddress: "docker.io", Username: "hub-user", Password: "hub-password"},
+		{ServerAddress: "https://registry.example.com:5000/", Username: "user", Password: "password"},
+		{ServerAddress: "token.example.com", IdentityToken: "identity-token"},
+	}))
+	server, ok := provider.(auth.AuthServer)
+	if !ok {
+		t.Fatalf("buildAuthProvider() = %T, want an auth.AuthServer", provider)
+	}
+
+	tests := []struct {
+		host       string
+		wantUser   string
+		wantSecret string
+	}{
+		{"registry-1.docker.io", "hub-user", "hub-password"},
+		{"registry.example.com:5000", "user", "password"},
+		{"token.example.com", "", "identity-token"},
+		{"other.example.com", "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			resp, err := server.Credentials(context.Background(), &auth.CredentialsRequest{Host: tt.host})
+			if err != nil {
+				t.Fatalf("Credentials() = %v", err)
+			}
+			if resp.Username != tt.wantUser || resp.Secret != tt.wantSecret {
+				t.Errorf("Credentials() = %q, %q, want %q, %q", resp.Username, resp.Secret, tt.wantUser, tt.wantSecret)
+			}
+		})
+	}
+}
+
+func TestBuildImageWithSecret(t *testing.T) {
+	requireDocker(t)
+	ctx := context.Background()
+
+	const (
+		name   = "kubicle-test/build-secret:latest"
+		secret = "kubicle-test-secret-6f1c0a"
+	)
+	contextFS := fstest.MapFS{
+		"Dockerfile": {Data: []byte("FROM busybox\n" +
+			"RUN --mount=type=secret,id=token test \"$(cat /run/secrets/token)\" = " + secret + " && touch /secret-was-mounted\n")},
+	}
+	err := BuildImageWithOptions(ctx, name, tarFS(contextFS, "."), BuildOptions{
+		Secrets: []BuildSecret{{ID: "token", Value: []byte(secret)}},
+	})
+	if err != nil {
+		t.Fatalf("BuildImageWithOptions() = %v", err)
+	}
+	t.Cleanup(func() { _ = DeleteImage(context.Background(), name) })
+
+	// docker save writes the layers uncompressed, so the secret would show
+	// up verbatim if any layer held it.
+	var image bytes.Buffer
+	if err := saveImage(ctx, &image, name); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(image.Bytes(), []byte(secret)) {
+		t.Error("secret was found in the image's layers")
+	}
+}
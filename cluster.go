@@ -1,44 +1,170 @@
 package kubicle
 
 import (
+	"bytes"
 	"context"
 	_ "embed"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
 	"os"
+	"path"
+	"slices"
+	"strings"
+	"sync"
 	"text/template"
 	"time"
 
+	cerrdefs "github.com/containerd/errdefs"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/version"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/kind/pkg/apis/config/v1alpha4"
 	"sigs.k8s.io/kind/pkg/cluster"
+	"sigs.k8s.io/yaml"
 )
 
 //go:embed config-template.yaml
 var configTemplate string
 
-func writeOutConfigTemplate(address string) (string, error) {
-	tmplt, err := template.New("config").Parse(configTemplate)
-	if err != nil {
-		return "", fmt.Errorf("failed to parse config template: %w", err)
+// configTemplateData is the data config-template.yaml is rendered with.
+type configTemplateData struct {
+	Nodes []configTemplateNode
+	// Networking is nil unless an option changed kind's networking
+	// defaults.
+	Networking *configTemplateNetworking
+	// FeatureGates are the Kubernetes feature gates to set on all
+	// components, keyed by gate name.
+	FeatureGates map[string]bool
+	// RuntimeConfig are the API server's --runtime-config values, e.g.
+	// "api/alpha": "true".
+	RuntimeConfig map[string]string
+}
+
+// configTemplateNode describes a single kind node in the rendered config.
+type configTemplateNode struct {
+	Role              string
+	Image             string
+	ExtraMounts       []configTemplateMount
+	ExtraPortMappings []configTemplatePortMapping
+}
+
+// configTemplateNetworking describes the cluster's networking settings.
+type configTemplateNetworking struct {
+	// IPFamily is one of ipv4, ipv6 or dual, or empty for kind's default.
+	IPFamily          string
+	DisableDefaultCNI bool
+	PodSubnet         string
+	ServiceSubnet     string
+}
+
+// configTemplateMount describes a host path mounted into a kind node.
+type configTemplateMount struct {
+	HostPath      string
+	ContainerPath string
+}
+
+// configTemplatePortMapping describes a kind node port published on the host.
+type configTemplatePortMapping struct {
+	ContainerPort int
+	HostPort      int
+	// Protocol is one of TCP, UDP or SCTP, as kind expects.
+	Protocol string
+}
+
+func newConfigTemplateData(cfg clusterConfig) configTemplateData {
+	data := configTemplateData{
+		Nodes: []configTemplateNode{
+			{
+				Role:        "control-plane",
+				Image:       cfg.nodeImage,
+				ExtraMounts: cfg.extraMounts,
+			},
+		},
+		FeatureGates:  cfg.featureGates,
+		RuntimeConfig: cfg.runtimeConfig,
 	}
-	data := map[string]string{
-		"Address": address,
+	for _, pm := range cfg.extraPortMappings {
+		protocol := strings.ToUpper(pm.Protocol)
+		if protocol == "" {
+			protocol = "TCP"
+		}
+		data.Nodes[0].ExtraPortMappings = append(data.Nodes[0].ExtraPortMappings, configTemplatePortMapping{
+			ContainerPort: pm.Container,
+			HostPort:      pm.Host,
+			Protocol:      protocol,
+		})
 	}
-
-	file, err := os.CreateTemp("", "kind-config-*.yaml")
-	if err != nil {
-		return "", fmt.Errorf("failed to create temp file: %w", err)
+	if cfg.ipFamily != "" || cfg.disableDefaultCNI || cfg.podSubnet.IsValid() || cfg.serviceSubnet.IsValid() {
+		data.Networking = &configTemplateNetworking{
+			IPFamily:          cfg.ipFamily,
+			DisableDefaultCNI: cfg.disableDefaultCNI,
+		}
+		if cfg.podSubnet.IsValid() {
+			data.Networking.PodSubnet = cfg.podSubnet.String()
+		}
+		if cfg.serviceSubnet.IsValid() {
+			data.Networking.ServiceSubnet = cfg.serviceSubnet.String()
+		}
 	}
+	for i := 0; i < cfg.workerNodes; i++ {
+		data.Nodes = append(data.Nodes, configTemplateNode{Role: "worker", Image: cfg.nodeImage})
+	}
+	return data
+}
 
-	defer file.Close()
+// registryContainerdPatch points containerd at per-registry host
+// configuration under containerdCertsDir, which configureNodeRegistryHosts
+// fills in once the nodes are up.
+const registryContainerdPatch = `[plugins."io.containerd.grpc.v1.cri".registry]
+  config_path = "` + containerdCertsDir + `"`
 
-	err = tmplt.Execute(file, data)
-	if err != nil {
-		return "", fmt.Errorf("failed to execute config template: %w", err)
+// kindConfig returns the kind configuration a cluster is created from: the
+// configuration passed to WithKindConfig, or else the config template, either
+// the embedded one or the one passed to WithConfigTemplate, rendered with cfg.
+// Unless the registry is disabled and no mirrors are configured, the
+// registry's containerd patch is added to any patches the configuration
+// already has.
+func kindConfig(cfg clusterConfig) (*v1alpha4.Cluster, error) {
+	var kindCfg *v1alpha4.Cluster
+	if cfg.kindConfig != nil {
+		kindCfg = cfg.kindConfig.DeepCopy()
+	} else {
+		text := configTemplate
+		if cfg.configTemplate != "" {
+			text = cfg.configTemplate
+		}
+		tmplt, err := template.New("config").Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse config template: %w", err)
+		}
+
+		var buf bytes.Buffer
+		err = tmplt.Execute(&buf, newConfigTemplateData(cfg))
+		if err != nil {
+			return nil, fmt.Errorf("failed to execute config template: %w", err)
+		}
+
+		kindCfg = &v1alpha4.Cluster{}
+		err = yaml.UnmarshalStrict(buf.Bytes(), kindCfg)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode kind config: %w", err)
+		}
 	}
 
-	return file.Name(), nil
+	if !cfg.withoutRegistry || len(cfg.registryMirrors) > 0 {
+		kindCfg.ContainerdConfigPatches = append(kindCfg.ContainerdConfigPatches, registryContainerdPatch)
+	}
+	return kindCfg, nil
 }
 
 // Cluster represents a local kind Kubernetes cluster with an associated
@@ -46,148 +172,956 @@ func writeOutConfigTemplate(address string) (string, error) {
 type Cluster struct {
 	Name       string
 	Kubeconfig string
+	// RESTConfig is the client configuration the Clientset was built from.
+	// It can be used to construct other clients for the cluster.
+	RESTConfig *rest.Config
 	Delete     func(context.Context) error
 	*kubernetes.Clientset
+
+	// provider is nil for clusters attached with NewClusterFromKubeconfig.
+	provider *cluster.Provider
+	// registryAddr is the registry passed to NewClusterFromKubeconfig.
+	registryAddr string
+	// config holds the options the cluster was created or reused with, so
+	// Restart can recreate it.
+	config       clusterConfig
+	registryPort int
+	// registryCACert is the PEM encoded CA of a TLS registry.
+	registryCACert []byte
+	logger         *slog.Logger
+
+	// builtImages are the local images tagged for the registry, which
+	// Delete removes in case a failed push left them behind.
+	builtImagesMu sync.Mutex
+	builtImages   map[string]bool
+
+	// kubeconfigPath caches the file written by KubeconfigPath.
+	kubeconfigMu   sync.Mutex
+	kubeconfigPath string
 }
 
+// ErrClusterUnhealthy is returned by NewCluster when a cluster of the given
+// name exists but its API server cannot be reached, e.g. because its node
+// container was stopped or removed out of band. Callers can delete it with
+// DeleteCluster and try again.
+var ErrClusterUnhealthy = errors.New("cluster exists but is unhealthy")
+
+// ErrClusterExists is returned by NewCluster when a cluster of the given name
+// already exists and reusing it was ruled out with WithReuse(false).
+var ErrClusterExists = errors.New("cluster already exists")
+
+// clusterHealthTimeout bounds the health check of a reused cluster, so a dead
+// API server fails fast instead of hanging the first API call.
+const clusterHealthTimeout = 10 * time.Second
+
 // NewCluster creates or reuses a kind cluster with the given name.
-// If a cluster with that name already exists, it reconnects to it.
-// Otherwise, a new cluster is created with the given timeout for readiness.
+// If a cluster with that name already exists, it reconnects to it, unless
+// WithReuse(false) is used. Otherwise, a new cluster is created and waited on
+// until it is ready.
 // A local Docker registry is also created and attached to the cluster network.
-func NewCluster(ctx context.Context, name string, timeout time.Duration) (*Cluster, error) {
-	provider := cluster.NewProvider(
+//
+// Without options, NewCluster waits up to 5 minutes for a new cluster to be
+// ready and publishes the registry on host port 5000.
+//
+// If the Docker daemon cannot be reached, the returned error wraps
+// ErrDockerUnavailable. If an existing cluster does not respond, it wraps
+// ErrClusterUnhealthy; if it may not be reused, it wraps ErrClusterExists.
+func NewCluster(ctx context.Context, name string, opts ...ClusterOption) (_ *Cluster, err error) {
+	cfg := defaultClusterConfig()
+	for _, opt := range opts {
+		if err := opt(&cfg); err != nil {
+			return nil, fmt.Errorf("invalid cluster option: %w", err)
+		}
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, fmt.Errorf("invalid cluster option: %w", err)
+	}
+
+	err = pingDocker(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	providerOpts := []cluster.ProviderOption{
 		cluster.ProviderWithDocker(),
-	)
+	}
+	if cfg.logger != nil {
+		providerOpts = append(providerOpts, cluster.ProviderWithLogger(kindLogger{logger: cfg.logger}))
+	}
+	provider := cluster.NewProvider(providerOpts...)
 
 	clusters, err := provider.List()
 	if err != nil {
 		return nil, fmt.Errorf("failed to list clusters: %w", err)
 	}
 
-	var kubeconfig string
-	for _, c := range clusters {
-		if c == name {
-			kubeconfig, err = provider.KubeConfig(name, false)
-			if err != nil {
-				return nil, fmt.Errorf("failed to get kubeconfig: %w", err)
+	// Don't leave a cluster or registry the caller has no handle on behind if
+	// any of the steps after creating them fail. Reused ones, including a
+	// registry kept from an earlier session, are left alone.
+	var created bool
+	var registry registrySetup
+	defer func() {
+		if err == nil {
+			return
+		}
+		cleanupCtx := context.WithoutCancel(ctx)
+		if registry.created {
+			cfg.log().Debug("removing registry after failed setup", "cluster", name)
+			if cleanupErr := removeRegistry(cleanupCtx, name, registry.port); cleanupErr != nil {
+				err = errors.Join(err, fmt.Errorf("failed to clean up registry: %w", cleanupErr))
 			}
-			break
 		}
+		if created {
+			cfg.log().Debug("deleting cluster after failed setup", "cluster", name)
+			if cleanupErr := provider.Delete(name, ""); cleanupErr != nil {
+				err = errors.Join(err, fmt.Errorf("failed to clean up cluster: %w", cleanupErr))
+			}
+		}
+	}()
+
+	var kubeconfig string
+	reused := slices.Contains(clusters, name)
+	if reused && !cfg.reuse {
+		return nil, fmt.Errorf("%w: %s", ErrClusterExists, name)
 	}
-	if kubeconfig == "" {
-		configFilePath, err := writeOutConfigTemplate(fmt.Sprintf("%s-registry:5000", name))
+	if reused {
+		cfg.log().Debug("reusing existing cluster", "cluster", name)
+		// kind reads the kubeconfig from the control-plane container, so
+		// this fails if the container is gone or stopped.
+		kubeconfig, err = provider.KubeConfig(name, false)
 		if err != nil {
-			return nil, fmt.Errorf("failed to write out config template: %w", err)
+			return nil, fmt.Errorf("%w: failed to get kubeconfig: %w", ErrClusterUnhealthy, err)
+		}
+	} else {
+		var kindCfg *v1alpha4.Cluster
+		kindCfg, err = kindConfig(cfg)
+		if err != nil {
+			return nil, err
 		}
-		defer os.Remove(configFilePath)
 
-		err = provider.Create(name,
-			cluster.CreateWithConfigFile(configFilePath),
-			cluster.CreateWithWaitForReady(timeout),
-			cluster.CreateWithDisplayUsage(true),
-			cluster.CreateWithDisplaySalutation(true),
-		)
+		cfg.log().Debug("creating cluster", "cluster", name)
+		err = createKindCluster(ctx, provider, name, kindCfg, cfg)
 		if err != nil {
 			return nil, fmt.Errorf("failed to create cluster: %w", err)
 		}
+		created = true
+
 		kubeconfig, err = provider.KubeConfig(name, false)
 		if err != nil {
 			return nil, fmt.Errorf("failed to get kubeconfig: %w", err)
 		}
 	}
 
-	config, err := clientcmd.RESTConfigFromKubeConfig([]byte(kubeconfig))
+	config, cs, err := newClients(kubeconfig)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create client config: %w", err)
+		return nil, err
 	}
-	cs, err := kubernetes.NewForConfig(config)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create clientset: %w", err)
+
+	if reused {
+		err = checkClusterHealth(ctx, cs)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	err = createRegistryInNetwork(ctx, name)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create registry in network: %w", err)
+	if !cfg.withoutRegistry {
+		registry, err = setupRegistry(ctx, provider, name, cfg)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(cfg.registryMirrors) > 0 {
+		err = configureRegistryMirrors(provider, name, cfg)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	cluster := Cluster{
+	cluster := &Cluster{
 		Name:       name,
 		Kubeconfig: kubeconfig,
+		RESTConfig: config,
+		Clientset:  cs,
+
+		provider:       provider,
+		config:         cfg,
+		registryPort:   registry.port,
+		registryCACert: registry.caCert,
+		logger:         cfg.log(),
+	}
+	cluster.Delete = cluster.delete
+
+	return cluster, nil
+}
+
+// ErrNotKindCluster is returned by methods that manage the cluster's kind
+// nodes or registry container when the cluster was attached with
+// NewClusterFromKubeconfig.
+var ErrNotKindCluster = errors.New("cluster was not created by kind")
+
+// NewClusterFromKubeconfig attaches to an existing cluster that kubicle did
+// not create, e.g. a shared development cluster, through the current context
+// of kubeconfig. Images are pushed to registryAddr, which must be reachable
+// from the host and from the cluster's nodes under the same address, and
+// ImageName prefixes it. The registry's API is queried over HTTPS unless
+// registryAddr is on the loopback interface, e.g. "localhost:5000", where
+// plain HTTP is used. If registryAddr is empty, the cluster behaves as if
+// created WithoutRegistry. Delete is a no-op, and methods that need kind
+// nodes return ErrNotKindCluster.
+func NewClusterFromKubeconfig(ctx context.Context, kubeconfig []byte, registryAddr string) (*Cluster, error) {
+	rawConfig, err := clientcmd.Load(kubeconfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load kubeconfig: %w", err)
+	}
+	config, cs, err := newClients(string(kubeconfig))
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := defaultClusterConfig()
+	cfg.withoutRegistry = registryAddr == ""
+	return &Cluster{
+		Name:       rawConfig.CurrentContext,
+		Kubeconfig: string(kubeconfig),
+		RESTConfig: config,
+		Delete:     func(context.Context) error { return nil },
 		Clientset:  cs,
-		Delete: func(ctx context.Context) error {
-			registryName := fmt.Sprintf("%s-registry", name)
-			var errs []error
 
-			if err := RemoveContainer(ctx, registryName); err != nil {
-				errs = append(errs, fmt.Errorf("failed to remove registry container: %w", err))
+		registryAddr: registryAddr,
+		config:       cfg,
+		logger:       cfg.log(),
+	}, nil
+}
+
+// checkKind returns ErrNotKindCluster if the cluster was attached with
+// NewClusterFromKubeconfig.
+func (c *Cluster) checkKind() error {
+	if c.provider == nil {
+		return ErrNotKindCluster
+	}
+	return nil
+}
+
+// delete removes the cluster, its registry and any images built for it that
+// were left in the local Docker daemon. Resources that are already gone are
+// skipped, so deleting a cluster twice is not an error.
+func (c *Cluster) delete(ctx context.Context) error {
+	var errs []error
+
+	if !c.config.withoutRegistry {
+		// removeRegistry skips the registry if Delete was called before.
+		if err := removeRegistry(ctx, c.Name, c.registryPort); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if c.config.persistentRegistry && c.config.removeRegistryVolume {
+		if err := RemoveVolume(ctx, registryVolumeName(c.Name)); err != nil {
+			errs = append(errs, fmt.Errorf("failed to remove registry volume: %w", err))
+		}
+	}
+
+	if err := c.removeBuiltImages(ctx); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err := c.provider.Delete(c.Name, ""); err != nil {
+		errs = append(errs, fmt.Errorf("failed to delete cluster: %w", err))
+	}
+
+	return errors.Join(errs...)
+}
+
+// removeRegistry removes the cluster's registry container, published on host
+// port port, and forgets its CA. A registry that is already gone is skipped.
+func removeRegistry(ctx context.Context, clusterName string, port int) error {
+	clearRegistryCA(fmt.Sprintf("localhost:%d", port))
+	err := RemoveContainer(ctx, fmt.Sprintf("%s-registry", clusterName))
+	if err != nil && !cerrdefs.IsNotFound(err) {
+		return fmt.Errorf("failed to remove registry container: %w", err)
+	}
+	return nil
+}
+
+// kindNetworkEnv is the variable kind reads the Docker network to create
+// nodes in from.
+const kindNetworkEnv = "KIND_EXPERIMENTAL_DOCKER_NETWORK"
+
+// kindNetworkMu serializes cluster creations, which all depend on
+// kindNetworkEnv.
+var kindNetworkMu sync.Mutex
+
+// createKindCluster creates the kind cluster from kindCfg. kind cannot be
+// interrupted, so if ctx is cancelled first createKindCluster returns
+// immediately and deletes the cluster in the background once kind finishes.
+func createKindCluster(ctx context.Context, provider *cluster.Provider, name string, kindCfg *v1alpha4.Cluster, cfg clusterConfig) error {
+	// Without a CNI the nodes stay NotReady, so only wait for kubeadm to
+	// bring up the control plane.
+	waitForReady := cfg.readyTimeout
+	if kindCfg.Networking.DisableDefaultCNI {
+		waitForReady = 0
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		// kind only takes the network from the environment, which is
+		// process-wide, so hold it for the whole creation, also when the
+		// default network is used, lest a concurrent creation's network
+		// leak into this one.
+		kindNetworkMu.Lock()
+		defer kindNetworkMu.Unlock()
+		previous, wasSet := os.LookupEnv(kindNetworkEnv)
+		if cfg.dockerNetwork != "" {
+			os.Setenv(kindNetworkEnv, cfg.dockerNetwork)
+		} else {
+			os.Unsetenv(kindNetworkEnv)
+		}
+		defer func() {
+			if wasSet {
+				os.Setenv(kindNetworkEnv, previous)
+			} else {
+				os.Unsetenv(kindNetworkEnv)
 			}
+		}()
+		done <- provider.Create(name,
+			cluster.CreateWithV1Alpha4Config(kindCfg),
+			cluster.CreateWithWaitForReady(waitForReady),
+			cluster.CreateWithDisplayUsage(cfg.logger == nil),
+			cluster.CreateWithDisplaySalutation(cfg.logger == nil),
+		)
+	}()
 
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		go func() {
+			<-done
 			if err := provider.Delete(name, ""); err != nil {
-				errs = append(errs, fmt.Errorf("failed to delete cluster: %w", err))
+				cfg.log().Warn("failed to delete cancelled cluster", "cluster", name, "error", err)
 			}
-
-			return errors.Join(errs...)
-		},
+		}()
+		return ctx.Err()
 	}
+}
 
-	return &cluster, nil
+// registrySetup describes the registry createRegistryInNetwork set up.
+type registrySetup struct {
+	// port is the host port the registry is published on.
+	port int
+	// caCert is the PEM encoded CA certificate of a TLS registry.
+	caCert []byte
+	// created reports whether the registry container was created rather
+	// than an existing one reused.
+	created bool
 }
 
-func createRegistryInNetwork(ctx context.Context, clusterName string) error {
-	err := PullImage(ctx, "registry:2")
+// createRegistryInNetwork ensures the cluster's registry container exists, is
+// attached to the cluster network and is running, repairing an existing
+// registry if needed, e.g. after a Docker daemon restart. An existing registry
+// keeps the port and certificates it was created with, which may differ from
+// the configured ones. If it fails after creating the container, the returned
+// registrySetup still reports it as created.
+func createRegistryInNetwork(ctx context.Context, clusterName string, cfg clusterConfig) (registrySetup, error) {
+	setup := registrySetup{port: cfg.registryPort}
+
+	// A pull over a stalled connection would otherwise hang creation for as
+	// long as ctx allows, which may be forever.
+	pullCtx, cancel := context.WithTimeout(ctx, cfg.readyTimeout)
+	defer cancel()
+	err := cfg.retry.do(pullCtx, func() error {
+		return PullImage(pullCtx, cfg.registryImage)
+	})
 	if err != nil {
-		return fmt.Errorf("failed to pull registry image: %w", err)
+		if ctx.Err() == nil && errors.Is(pullCtx.Err(), context.DeadlineExceeded) {
+			return setup, fmt.Errorf("timed out after %s pulling registry image %s: %w", cfg.readyTimeout, cfg.registryImage, context.DeadlineExceeded)
+		}
+		return setup, fmt.Errorf("failed to pull registry image: %w", err)
 	}
 
 	registryContainerName := fmt.Sprintf("%s-registry", clusterName)
 	exists, err := ContainerExists(ctx, registryContainerName)
 	if err != nil {
-		return fmt.Errorf("failed to check if registry container exists: %w", err)
+		return setup, fmt.Errorf("failed to check if registry container exists: %w", err)
 	}
 	if exists {
-		return nil
+		if err := checkRegistryOwner(ctx, registryContainerName, clusterName); err != nil {
+			return setup, err
+		}
+		setup.port, err = GetContainerHostPort(ctx, registryContainerName, registryContainerPort, "tcp")
+		if err != nil {
+			return setup, fmt.Errorf("failed to get registry host port: %w", err)
+		}
+		if cfg.registryTLS {
+			setup.caCert, err = readContainerFile(ctx, registryContainerName, path.Join(registryCertsDir, registryCACertFile))
+			if err != nil {
+				return setup, fmt.Errorf("failed to read CA certificate of existing registry, it may have been created without TLS: %w", err)
+			}
+		}
+	} else {
+		// Docker only reports a taken port once the container starts, so
+		// check up front rather than leave a container behind that can
+		// never start.
+		if err := checkPortFree(setup.port); err != nil {
+			return setup, err
+		}
+
+		// Allow images to be deleted so long-running sessions can reclaim
+		// space, see DeleteImageFromRegistry.
+		env := []string{"REGISTRY_STORAGE_DELETE_ENABLED=true"}
+		if cfg.registryTLS {
+			env = append(env,
+				"REGISTRY_HTTP_TLS_CERTIFICATE="+path.Join(registryCertsDir, registryCertFile),
+				"REGISTRY_HTTP_TLS_KEY="+path.Join(registryCertsDir, registryKeyFile),
+			)
+		}
+
+		cfg.log().Debug("creating registry container", "container", registryContainerName, "hostPort", setup.port)
+		_, err = CreateContainerWithOptions(ctx, registryContainerName, cfg.registryImage, ContainerOptions{
+			PortMappings: []PortMap{
+				{
+					Host:      setup.port,
+					Container: registryContainerPort,
+					Protocol:  "tcp",
+				},
+			},
+			Env:     env,
+			Volumes: registryVolumes(clusterName, cfg),
+			Labels: map[string]string{
+				clusterLabel: clusterName,
+			},
+			// Bring the registry back after a reboot or Docker daemon
+			// restart, like kind does for the nodes. Delete still
+			// force-removes it.
+			RestartPolicy: "unless-stopped",
+		})
+		if err != nil {
+			return setup, fmt.Errorf("failed to create registry container: %w", err)
+		}
+		setup.created = true
+
+		if cfg.registryTLS {
+			// The certificate is valid for the name the nodes use and
+			// for the host address kubicle and the Docker daemon use.
+			certs, err := generateRegistryCerts([]string{registryContainerName, "localhost", "127.0.0.1", "::1"})
+			if err != nil {
+				return setup, err
+			}
+			err = copyFilesToContainer(ctx, registryContainerName, map[string][]byte{
+				path.Join(registryCertsDir, registryCACertFile): certs.caCert,
+				path.Join(registryCertsDir, registryCertFile):   certs.cert,
+				path.Join(registryCertsDir, registryKeyFile):    certs.key,
+			})
+			if err != nil {
+				return setup, fmt.Errorf("failed to copy certificates to registry container: %w", err)
+			}
+			setup.caCert = certs.caCert
+		}
 	}
 
-	registryContainerID, err := CreateContainer(ctx, registryContainerName, "registry:2", []PortMap{
-		{
-			Host:      5000,
-			Container: 5000,
-			Protocol:  "tcp",
-		},
+	registryNetworks := []string{cfg.dockerNetwork}
+	if cfg.dockerNetwork == "" {
+		clusterControlPlaneNodeName := controlPlaneContainerName(clusterName)
+		clusterNetworks, err := GetContainerNetworks(ctx, clusterControlPlaneNodeName)
+		if err != nil {
+			return setup, fmt.Errorf("failed to get container networks: %w", err)
+		}
+		registryNetworks, err = selectRegistryNetworks(clusterNetworks)
+		if err != nil {
+			return setup, err
+		}
+	}
+
+	attachedNetworks, err := GetContainerNetworks(ctx, registryContainerName)
+	if err != nil {
+		return setup, fmt.Errorf("failed to get registry container networks: %w", err)
+	}
+	for _, network := range registryNetworks {
+		if slices.Contains(attachedNetworks, network) {
+			continue
+		}
+		cfg.log().Debug("attaching registry to network", "container", registryContainerName, "network", network)
+		err = AttachContainerToNetwork(ctx, registryContainerName, network)
+		if err != nil {
+			return setup, fmt.Errorf("failed to attach registry container to network %s: %w", network, err)
+		}
+	}
+
+	running, err := ContainerRunning(ctx, registryContainerName)
+	if err != nil {
+		return setup, fmt.Errorf("failed to check if registry container is running: %w", err)
+	}
+	if !running {
+		cfg.log().Debug("starting registry container", "container", registryContainerName)
+		err = StartContainer(ctx, registryContainerName)
+		if isPortInUseError(err) {
+			// Another process took the port between the check and the start,
+			// or since an existing registry was last running.
+			return setup, fmt.Errorf("failed to start registry container: %w: %d", ErrRegistryPortInUse, setup.port)
+		}
+		if err != nil {
+			return setup, fmt.Errorf("failed to start registry container: %w", err)
+		}
+	}
+
+	registryHost := fmt.Sprintf("localhost:%d", setup.port)
+	if setup.caCert != nil {
+		if err := setRegistryCA(registryHost, setup.caCert); err != nil {
+			return setup, err
+		}
+	} else {
+		// A TLS registry deleted earlier may have published the same port.
+		clearRegistryCA(registryHost)
+	}
+	err = waitForRegistry(ctx, registryHost)
+	if err != nil {
+		return setup, err
+	}
+
+	return setup, nil
+}
+
+// newClients builds a REST config and clientset from a kubeconfig.
+func newClients(kubeconfig string) (*rest.Config, *kubernetes.Clientset, error) {
+	config, err := clientcmd.RESTConfigFromKubeConfig([]byte(kubeconfig))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create client config: %w", err)
+	}
+	cs, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create clientset: %w", err)
+	}
+	return config, cs, nil
+}
+
+// setupRegistry creates or reuses the cluster's registry, attaches it to the
+// cluster network and points the nodes at it.
+func setupRegistry(ctx context.Context, provider *cluster.Provider, clusterName string, cfg clusterConfig) (registrySetup, error) {
+	setup, err := createRegistryInNetwork(ctx, clusterName, cfg)
+	if err != nil {
+		return setup, fmt.Errorf("failed to create registry in network: %w", err)
+	}
+
+	registryAddress := fmt.Sprintf("%s-registry:%d", clusterName, registryContainerPort)
+	hostsTOML := fmt.Sprintf("[host.%q]\n", "http://"+registryAddress)
+	if setup.caCert != nil {
+		err = writeNodeRegistryFile(provider, clusterName, registryAddress, registryCACertFile, string(setup.caCert))
+		if err != nil {
+			return setup, fmt.Errorf("failed to install registry CA on nodes: %w", err)
+		}
+		hostsTOML = fmt.Sprintf("[host.%q]\n  ca = %q\n", "https://"+registryAddress, path.Join(containerdCertsDir, registryAddress, registryCACertFile))
+	}
+	err = configureNodeRegistryHosts(provider, clusterName, map[string]string{
+		registryAddress: hostsTOML,
 	})
 	if err != nil {
-		return fmt.Errorf("failed to create registry container: %w", err)
+		return setup, fmt.Errorf("failed to configure registry on nodes: %w", err)
 	}
+	return setup, nil
+}
 
-	clusterControlPlaneNodeName := fmt.Sprintf("%s-control-plane", clusterName)
-	clusterNetworks, err := GetContainerNetworks(ctx, clusterControlPlaneNodeName)
+// configureRegistryMirrors points the nodes at the mirrors passed to
+// WithRegistryMirrors.
+func configureRegistryMirrors(provider *cluster.Provider, clusterName string, cfg clusterConfig) error {
+	err := configureNodeRegistryHosts(provider, clusterName, registryMirrorHosts(cfg.registryMirrors))
 	if err != nil {
-		return fmt.Errorf("failed to get container networks: %w", err)
+		return fmt.Errorf("failed to configure registry mirrors on nodes: %w", err)
+	}
+	return nil
+}
+
+// Restart deletes the cluster's nodes and recreates them from the options
+// passed to NewCluster, e.g. to recover from a node in a bad state. The
+// registry container is kept, so images pushed to it remain available.
+// Kubeconfig, RESTConfig and the Clientset are replaced in place; clients
+// built from the old RESTConfig must be recreated. If timeout is zero, the
+// cluster's ready timeout is used.
+func (c *Cluster) Restart(ctx context.Context, timeout time.Duration) error {
+	if err := c.checkKind(); err != nil {
+		return err
+	}
+	cfg := c.config
+	if timeout != 0 {
+		cfg.readyTimeout = timeout
+	}
+
+	c.logger.Debug("deleting cluster for restart", "cluster", c.Name)
+	if err := c.provider.Delete(c.Name, ""); err != nil {
+		return fmt.Errorf("failed to delete cluster: %w", err)
 	}
-	clusterNetwork := clusterNetworks[0]
 
-	err = AttachContainerToNetwork(ctx, registryContainerID, clusterNetwork)
+	kindCfg, err := kindConfig(cfg)
 	if err != nil {
-		return fmt.Errorf("failed to attach registry container to network: %w", err)
+		return err
+	}
+	c.logger.Debug("recreating cluster", "cluster", c.Name)
+	err = createKindCluster(ctx, c.provider, c.Name, kindCfg, cfg)
+	if err != nil {
+		return fmt.Errorf("failed to create cluster: %w", err)
 	}
 
-	err = StartContainer(ctx, registryContainerID)
+	kubeconfig, err := c.provider.KubeConfig(c.Name, false)
 	if err != nil {
-		return fmt.Errorf("failed to start registry container: %w", err)
+		return fmt.Errorf("failed to get kubeconfig: %w", err)
+	}
+	config, cs, err := newClients(kubeconfig)
+	if err != nil {
+		return err
+	}
+
+	var registry registrySetup
+	if !cfg.withoutRegistry {
+		registry, err = setupRegistry(ctx, c.provider, c.Name, cfg)
+		if err != nil {
+			return err
+		}
+	}
+	if len(cfg.registryMirrors) > 0 {
+		if err := configureRegistryMirrors(c.provider, c.Name, cfg); err != nil {
+			return err
+		}
+	}
+
+	c.Kubeconfig = kubeconfig
+	if err := c.refreshKubeconfigFile(); err != nil {
+		return err
 	}
+	c.RESTConfig = config
+	c.Clientset = cs
+	c.registryPort = registry.port
+	c.registryCACert = registry.caCert
+	return nil
+}
 
+// checkClusterHealth returns ErrClusterUnhealthy if the API server does not
+// report itself healthy within clusterHealthTimeout.
+func checkClusterHealth(ctx context.Context, cs *kubernetes.Clientset) error {
+	ctx, cancel := context.WithTimeout(ctx, clusterHealthTimeout)
+	defer cancel()
+
+	_, err := cs.Discovery().RESTClient().Get().AbsPath("/healthz").DoRaw(ctx)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrClusterUnhealthy, err)
+	}
 	return nil
 }
 
+// ServerVersion returns the version of the cluster's API server, e.g. to
+// check which Kubernetes version a test runs against.
+func (c *Cluster) ServerVersion(ctx context.Context) (*version.Info, error) {
+	body, err := c.Discovery().RESTClient().Get().AbsPath("/version").DoRaw(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get server version: %w", err)
+	}
+	var info version.Info
+	if err := json.Unmarshal(body, &info); err != nil {
+		return nil, fmt.Errorf("failed to decode server version: %w", err)
+	}
+	return &info, nil
+}
+
+// registryStoragePath is where the registry image keeps pushed images.
+const registryStoragePath = "/var/lib/registry"
+
+// registryVolumeName returns the name of the volume backing a persistent
+// registry.
+func registryVolumeName(clusterName string) string {
+	return fmt.Sprintf("%s-registry-data", clusterName)
+}
+
+// registryVolumes returns the volumes to mount into the registry container.
+func registryVolumes(clusterName string, cfg clusterConfig) map[string]string {
+	if !cfg.persistentRegistry {
+		return nil
+	}
+	return map[string]string{
+		registryVolumeName(clusterName): registryStoragePath,
+	}
+}
+
+// kindNetworkName is the Docker network kind attaches nodes to by default.
+const kindNetworkName = "kind"
+
+// selectRegistryNetworks picks which of the control-plane's networks the
+// registry should join. The kind network is preferred; if it is absent, the
+// registry joins all of them so the nodes can reach it whichever is in use.
+func selectRegistryNetworks(controlPlaneNetworks []string) ([]string, error) {
+	if len(controlPlaneNetworks) == 0 {
+		return nil, errors.New("control-plane container is not attached to any network")
+	}
+	for _, network := range controlPlaneNetworks {
+		if network == kindNetworkName {
+			return []string{network}, nil
+		}
+	}
+	return controlPlaneNetworks, nil
+}
+
 // BuildAndPushImage builds a Docker image from localPath and pushes it to the
 // cluster's local registry, making it available for use in the cluster.
 func (c *Cluster) BuildAndPushImage(ctx context.Context, imageName, localPath string) error {
-	return PushImageToClusterRegistry(ctx, imageName, localPath)
+	return c.BuildAndPushImageWithOptions(ctx, imageName, localPath, BuildOptions{})
 }
 
-// RegistryName returns the in-cluster address of the local Docker registry.
+// BuildAndPushImageWithOptions is like BuildAndPushImage but builds the image
+// using opts, e.g. to select a Dockerfile that is not at the context root.
+func (c *Cluster) BuildAndPushImageWithOptions(ctx context.Context, imageName, localPath string, opts BuildOptions) error {
+	_, err := c.BuildAndPushImageWithResult(ctx, imageName, localPath, opts)
+	return err
+}
+
+// BuildResult describes an image pushed to the cluster's registry. For dry
+// runs it is empty; for multi-platform images only Digest is set.
+type BuildResult struct {
+	// ID is the ID of the built image, e.g. "sha256:...".
+	ID string
+	// Digest is the digest of the pushed manifest, e.g. "sha256:...".
+	Digest string
+	// Size is the size of the built image in bytes, including its base
+	// image, as reported by docker image inspect.
+	Size int64
+	// Layers is the number of layers of the built image.
+	Layers int
+}
+
+// BuildAndPushImageWithResult is like BuildAndPushImageWithOptions but also
+// reports the digest of the pushed image, which can be passed to
+// ImageNameWithDigest to reference the image immutably, and its size, e.g.
+// to catch an image growing unexpectedly.
+func (c *Cluster) BuildAndPushImageWithResult(ctx context.Context, imageName, localPath string, opts BuildOptions) (BuildResult, error) {
+	if err := c.checkRegistry(); err != nil {
+		return BuildResult{}, err
+	}
+	if opts.Platform != "" && len(opts.Platforms) == 0 {
+		if err := c.checkPlatform(ctx, opts.Platform); err != nil {
+			return BuildResult{}, err
+		}
+	}
+
+	newContext := func() (io.Reader, error) {
+		return tarDirectory(localPath, opts.Dockerfile)
+	}
+
+	if len(opts.Platforms) > 0 {
+		c.logger.Debug("building and pushing multi-platform image", "image", imageName, "context", localPath, "platforms", opts.Platforms)
+		digest, err := c.pushMultiPlatformImage(ctx, imageName, newContext, opts)
+		if err != nil {
+			return BuildResult{}, err
+		}
+		return BuildResult{Digest: digest}, nil
+	}
+
+	c.logger.Debug("building and pushing image", "image", imageName, "context", localPath)
+	result, err := c.pushImageToRegistry(ctx, imageName, newContext, opts, c.config.retry)
+	if err != nil {
+		return BuildResult{}, err
+	}
+	c.logger.Debug("pushed image", "image", c.ImageName(imageName), "digest", result.Digest, "size", result.Size)
+	return result, nil
+}
+
+// BuildAndPushImageFS is like BuildAndPushImage but uses fsys as the build
+// context, e.g. an embed.FS. Use fs.Sub to build from a subdirectory of fsys.
+func (c *Cluster) BuildAndPushImageFS(ctx context.Context, imageName string, fsys fs.FS) error {
+	if err := c.checkRegistry(); err != nil {
+		return err
+	}
+	if err := checkDockerfile(fsys, ""); err != nil {
+		return err
+	}
+	newContext := func() (io.Reader, error) {
+		return tarFS(fsys, "."), nil
+	}
+	_, err := c.pushImageToRegistry(ctx, imageName, newContext, BuildOptions{}, c.config.retry)
+	return err
+}
+
+// BuildAndPushImageFromTar is like BuildAndPushImage but builds from
+// contextTar, a tar archive of the build context, e.g. one produced by an
+// earlier CI step. The archive may be compressed with gzip, bzip2 or xz.
+// Operations are only retried (see WithRetry) if contextTar is an io.Seeker,
+// such as an *os.File, so that it can be read again.
+func (c *Cluster) BuildAndPushImageFromTar(ctx context.Context, imageName string, contextTar io.Reader) error {
+	if err := c.checkRegistry(); err != nil {
+		return err
+	}
+	retry := retryPolicy{}
+	newContext := func() (io.Reader, error) {
+		return contextTar, nil
+	}
+	if seeker, ok := contextTar.(io.Seeker); ok {
+		retry = c.config.retry
+		newContext = func() (io.Reader, error) {
+			_, err := seeker.Seek(0, io.SeekStart)
+			return contextTar, err
+		}
+	}
+
+	_, err := c.pushImageToRegistry(ctx, imageName, newContext, BuildOptions{}, retry)
+	return err
+}
+
+// pushImageToRegistry builds and pushes imageName to the cluster's registry
+// like the package-level pushImageToRegistry, remembering the local images for
+// Delete.
+func (c *Cluster) pushImageToRegistry(ctx context.Context, imageName string, newContext func() (io.Reader, error), opts BuildOptions, retry retryPolicy) (BuildResult, error) {
+	names, err := registryImageNames(c.HostRegistryAddress(), imageName, opts.Tags)
+	if err != nil {
+		return BuildResult{}, err
+	}
+	for _, name := range names {
+		c.trackBuiltImage(name)
+	}
+	return pushImageToRegistry(ctx, c.HostRegistryAddress(), imageName, newContext, opts, retry)
+}
+
+// trackBuiltImage records a local image created for the registry.
+func (c *Cluster) trackBuiltImage(name string) {
+	c.builtImagesMu.Lock()
+	defer c.builtImagesMu.Unlock()
+
+	if c.builtImages == nil {
+		c.builtImages = map[string]bool{}
+	}
+	c.builtImages[name] = true
+}
+
+// removeBuiltImages removes the local images recorded by trackBuiltImage that
+// are still present. Images are normally removed right after being pushed,
+// but a failed push leaves them behind.
+func (c *Cluster) removeBuiltImages(ctx context.Context) error {
+	c.builtImagesMu.Lock()
+	defer c.builtImagesMu.Unlock()
+
+	var errs []error
+	for name := range c.builtImages {
+		err := DeleteImage(ctx, name)
+		if err != nil && !cerrdefs.IsNotFound(err) {
+			errs = append(errs, fmt.Errorf("failed to remove local image %s: %w", name, err))
+			continue
+		}
+		delete(c.builtImages, name)
+	}
+	return errors.Join(errs...)
+}
+
+// PushLocalImage pushes localRef, an image already in the local Docker daemon,
+// to the cluster's registry as clusterImageName without rebuilding it. Pods
+// reference it as ImageName(clusterImageName). If localRef is a tag, it is
+// left in place; an untagged image referenced by ID is removed after the push.
+func (c *Cluster) PushLocalImage(ctx context.Context, localRef, clusterImageName string) error {
+	if err := c.checkRegistry(); err != nil {
+		return err
+	}
+	registryImage := fmt.Sprintf("%s/%s", c.HostRegistryAddress(), clusterImageName)
+
+	c.trackBuiltImage(registryImage)
+	err := TagImage(ctx, localRef, registryImage)
+	if err != nil {
+		return err
+	}
+
+	c.logger.Debug("pushing local image", "image", localRef, "target", registryImage)
+	err = c.config.retry.do(ctx, func() error {
+		_, err := pushImage(ctx, registryImage, nil, nil)
+		return err
+	})
+	if err != nil {
+		err = fmt.Errorf("failed to push image to cluster registry: %w", err)
+	}
+
+	// Only the registry tag is removed if localRef is also a tag.
+	if rmErr := DeleteImage(ctx, registryImage); rmErr != nil {
+		err = errors.Join(err, fmt.Errorf("failed to delete image from local docker: %w", rmErr))
+	}
+	return err
+}
+
+// checkPlatform returns an error if the cluster has nodes that cannot run
+// images built for platform, e.g. "linux/arm64" images on amd64 nodes.
+func (c *Cluster) checkPlatform(ctx context.Context, platform string) error {
+	platformOS, platformArch, _, err := splitPlatform(platform)
+	if err != nil {
+		return err
+	}
+
+	nodes, err := c.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to list nodes: %w", err)
+	}
+	for _, node := range nodes.Items {
+		info := node.Status.NodeInfo
+		if info.OperatingSystem != platformOS || info.Architecture != platformArch {
+			return fmt.Errorf("platform %s does not match node %s (%s/%s)", platform, node.Name, info.OperatingSystem, info.Architecture)
+		}
+	}
+	return nil
+}
+
+// DynamicClient returns a dynamic client for the cluster, useful for working
+// with unstructured objects and custom resources.
+func (c *Cluster) DynamicClient() (dynamic.Interface, error) {
+	dc, err := dynamic.NewForConfig(c.RESTConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create dynamic client: %w", err)
+	}
+	return dc, nil
+}
+
+// ControllerRuntimeClient returns a controller-runtime client for the
+// cluster that maps types through scheme, for operator tests that work with
+// typed custom resources. If scheme is nil, only the built-in Kubernetes
+// types are known.
+func (c *Cluster) ControllerRuntimeClient(scheme *runtime.Scheme) (client.Client, error) {
+	if scheme == nil {
+		scheme = clientgoscheme.Scheme
+	}
+	cl, err := client.New(c.RESTConfig, client.Options{Scheme: scheme})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create controller-runtime client: %w", err)
+	}
+	return cl, nil
+}
+
+// RegistryName returns the in-cluster address of the local Docker registry,
+// or the registry passed to NewClusterFromKubeconfig.
 func (c *Cluster) RegistryName() string {
-	return fmt.Sprintf("%s-registry:5000", c.Name)
+	if c.registryAddr != "" {
+		return c.registryAddr
+	}
+	return fmt.Sprintf("%s-registry:%d", c.Name, registryContainerPort)
+}
+
+// RegistryCACert returns the PEM encoded CA certificate of the cluster's
+// registry if it was created WithRegistryTLS, or nil otherwise. Clients other
+// than the nodes and the Docker daemon need it to trust the registry.
+func (c *Cluster) RegistryCACert() []byte {
+	return slices.Clone(c.registryCACert)
+}
+
+// checkRegistry returns ErrRegistryDisabled if the cluster was created
+// WithoutRegistry.
+func (c *Cluster) checkRegistry() error {
+	if c.config.withoutRegistry {
+		return ErrRegistryDisabled
+	}
+	return nil
+}
+
+// HostRegistryAddress returns the address the registry is reachable at from
+// the host, e.g. "localhost:5000", for pushing to or querying the registry
+// directly. Pods reference images through RegistryName instead, which only
+// resolves inside the cluster's Docker network. A registry created
+// WithRegistryTLS serves HTTPS with a certificate signed by RegistryCACert.
+// It returns "" if the cluster was created WithoutRegistry.
+func (c *Cluster) HostRegistryAddress() string {
+	if c.registryAddr != "" {
+		return c.registryAddr
+	}
+	if c.config.withoutRegistry {
+		return ""
+	}
+	return fmt.Sprintf("localhost:%d", c.registryPort)
 }
 
 // ImageName returns the fully qualified image reference for use in Kubernetes
@@ -195,3 +1129,14 @@ func (c *Cluster) RegistryName() string {
 func (c *Cluster) ImageName(image string) string {
 	return fmt.Sprintf("%s/%s", c.RegistryName(), image)
 }
+
+// ImageNameWithDigest returns an image reference for use in Kubernetes pod
+// specs that pins image to the given digest, e.g. one reported in a
+// BuildResult. Any tag in image is dropped.
+func (c *Cluster) ImageNameWithDigest(image, digest string) string {
+	repository, _, _ := strings.Cut(image, "@")
+	if i := strings.LastIndex(repository, ":"); i > strings.LastIndex(repository, "/") {
+		repository = repository[:i]
+	}
+	return fmt.Sprintf("%s/%s@%s", c.RegistryName(), repository, digest)
+}
@@ -0,0 +1,334 @@
+package kubicle
+
+import (
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"sigs.k8s.io/kind/pkg/apis/config/v1alpha4"
+)
+
+// newTestConfig applies opts and validates the result like NewCluster does.
+func newTestConfig(opts ...ClusterOption) (clusterConfig, error) {
+	cfg := defaultClusterConfig()
+	for _, opt := range opts {
+		if err := opt(&cfg); err != nil {
+			return clusterConfig{}, err
+		}
+	}
+	return cfg, cfg.validate()
+}
+
+func TestKindConfig(t *testing.T) {
+	// Quotes in the path check that it is escaped in the rendered YAML.
+	mountDir := filepath.Join(t.TempDir(), `data "dir"`)
+	if err := os.Mkdir(mountDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		opts  []ClusterOption
+		check func(t *testing.T, cfg *v1alpha4.Cluster)
+	}{
+		{
+			name: "defaults",
+			check: func(t *testing.T, cfg *v1alpha4.Cluster) {
+				if len(cfg.Nodes) != 1 || cfg.Nodes[0].Role != v1alpha4.ControlPlaneRole {
+					t.Errorf("nodes = %+v, want a single control-plane node", cfg.Nodes)
+				}
+				if cfg.Networking != (v1alpha4.Networking{}) {
+					t.Errorf("networking = %+v, want none", cfg.Networking)
+				}
+				if !slices.Contains(cfg.ContainerdConfigPatches, registryContainerdPatch) {
+					t.Errorf("containerd patches = %q, want the registry patch", cfg.ContainerdConfigPatches)
+				}
+			},
+		},
+		{
+			name: "worker nodes",
+			opts: []ClusterOption{WithWorkerNodes(2)},
+			check: func(t *testing.T, cfg *v1alpha4.Cluster) {
+				var roles []v1alpha4.NodeRole
+				for _, node := range cfg.Nodes {
+					roles = append(roles, node.Role)
+				}
+				want := []v1alpha4.NodeRole{v1alpha4.ControlPlaneRole, v1alpha4.WorkerRole, v1alpha4.WorkerRole}
+				if !slices.Equal(roles, want) {
+					t.Errorf("roles = %v, want %v", roles, want)
+				}
+			},
+		},
+		{
+			name: "node image",
+			opts: []ClusterOption{WithWorkerNodes(1), WithNodeImage("kindest/node:v1.29.2")},
+			check: func(t *testing.T, cfg *v1alpha4.Cluster) {
+				for _, node := range cfg.Nodes {
+					if node.Image != "kindest/node:v1.29.2" {
+						t.Errorf("%s node image = %q, want %q", node.Role, node.Image, "kindest/node:v1.29.2")
+					}
+				}
+			},
+		},
+		{
+			name: "extra mount",
+			opts: []ClusterOption{WithExtraMount(mountDir, "/data")},
+			check: func(t *testing.T, cfg *v1alpha4.Cluster) {
+				want := []v1alpha4.Mount{{HostPath: mountDir, ContainerPath: "/data"}}
+				if !slices.Equal(cfg.Nodes[0].ExtraMounts, want) {
+					t.Errorf("extra mounts = %+v, want %+v", cfg.Nodes[0].ExtraMounts, want)
+				}
+			},
+		},
+		{
+			name: "extra port mappings",
+			opts: []ClusterOption{WithExtraPortMappings(
+				PortMap{Host: 8080, Container: 30080},
+				PortMap{Protocol: "udp", Host: 5353, Container: 53},
+			)},
+			check: func(t *testing.T, cfg *v1alpha4.Cluster) {
+				want := []v1alpha4.PortMapping{
+					{HostPort: 8080, ContainerPort: 30080, Protocol: v1alpha4.PortMappingProtocolTCP},
+					{HostPort: 5353, ContainerPort: 53, Protocol: v1alpha4.PortMappingProtocolUDP},
+				}
+				if !slices.Equal(cfg.Nodes[0].ExtraPortMappings, want) {
+					t.Errorf("port mappings = %+v, want %+v", cfg.Nodes[0].ExtraPortMappings, want)
+				}
+			},
+		},
+		{
+			name: "without registry",
+			opts: []ClusterOption{WithoutRegistry()},
+			check: func(t *testing.T, cfg *v1alpha4.Cluster) {
+				if len(cfg.ContainerdConfigPatches) != 0 {
+					t.Errorf("containerd patches = %q, want none", cfg.ContainerdConfigPatches)
+				}
+			},
+		},
+		{
+			name: "config template",
+			opts: []ClusterOption{
+				WithWorkerNodes(1),
+				WithConfigTemplate("kind: Cluster\napiVersion: kind.x-k8s.io/v1alpha4\nname: {{ len .Nodes }}-nodes\n"),
+			},
+			check: func(t *testing.T, cfg *v1alpha4.Cluster) {
+				if cfg.Name != "2-nodes" {
+					t.Errorf("name = %q, want the template rendered with the nodes", cfg.Name)
+				}
+			},
+		},
+		{
+			name: "kind config",
+			opts: []ClusterOption{
+				WithWorkerNodes(3),
+				WithKindConfig(v1alpha4.Cluster{
+					Nodes:                   []v1alpha4.Node{{Role: v1alpha4.ControlPlaneRole}},
+					ContainerdConfigPatches: []string{"# user patch"},
+				}),
+			},
+			check: func(t *testing.T, cfg *v1alpha4.Cluster) {
+				if len(cfg.Nodes) != 1 {
+					t.Errorf("nodes = %+v, want the kind config's single node", cfg.Nodes)
+				}
+				want := []string{"# user patch", registryContainerdPatch}
+				if !slices.Equal(cfg.ContainerdConfigPatches, want) {
+					t.Errorf("containerd patches = %q, want %q", cfg.ContainerdConfigPatches, want)
+				}
+			},
+		},
+		{
+			name: "without registry but with mirrors",
+			opts: []ClusterOption{
+				WithoutRegistry(),
+				WithRegistryMirrors(map[string]string{"docker.io": "http://mirror:5000"}),
+			},
+			check: func(t *testing.T, cfg *v1alpha4.Cluster) {
+				if !slices.Equal(cfg.ContainerdConfigPatches, []string{registryContainerdPatch}) {
+					t.Errorf("containerd patches = %q, want the registry patch", cfg.ContainerdConfigPatches)
+				}
+			},
+		},
+		{
+			name: "feature gates and runtime config",
+			opts: []ClusterOption{
+				WithFeatureGates(map[string]bool{"InPlacePodVerticalScaling": true}),
+				WithFeatureGates(map[string]bool{"SidecarContainers": false}),
+				WithRuntimeConfig(map[string]string{"api/alpha": "true"}),
+			},
+			check: func(t *testing.T, cfg *v1alpha4.Cluster) {
+				wantGates := map[string]bool{"InPlacePodVerticalScaling": true, "SidecarContainers": false}
+				if !maps.Equal(cfg.FeatureGates, wantGates) {
+					t.Errorf("feature gates = %v, want %v", cfg.FeatureGates, wantGates)
+				}
+				wantRuntime := map[string]string{"api/alpha": "true"}
+				if !maps.Equal(cfg.RuntimeConfig, wantRuntime) {
+					t.Errorf("runtime config = %v, want %v", cfg.RuntimeConfig, wantRuntime)
+				}
+			},
+		},
+		{
+			name: "default CNI disabled",
+			opts: []ClusterOption{WithDefaultCNIDisabled()},
+			check: func(t *testing.T, cfg *v1alpha4.Cluster) {
+				want := v1alpha4.Networking{DisableDefaultCNI: true}
+				if cfg.Networking != want {
+					t.Errorf("networking = %+v, want %+v", cfg.Networking, want)
+				}
+			},
+		},
+		{
+			name: "subnets",
+			opts: []ClusterOption{
+				WithPodSubnet("10.100.1.2/16"),
+				WithServiceSubnet("10.200.0.0/16"),
+			},
+			check: func(t *testing.T, cfg *v1alpha4.Cluster) {
+				want := v1alpha4.Networking{
+					PodSubnet:     "10.100.0.0/16",
+					ServiceSubnet: "10.200.0.0/16",
+				}
+				if cfg.Networking != want {
+					t.Errorf("networking = %+v, want %+v", cfg.Networking, want)
+				}
+			},
+		},
+		{
+			name: "dual-stack",
+			opts: []ClusterOption{WithIPFamily("dual"), WithDefaultCNIDisabled()},
+			check: func(t *testing.T, cfg *v1alpha4.Cluster) {
+				want := v1alpha4.Networking{
+					IPFamily:          v1alpha4.DualStackFamily,
+					DisableDefaultCNI: true,
+				}
+				if cfg.Networking != want {
+					t.Errorf("networking = %+v, want %+v", cfg.Networking, want)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := newTestConfig(tt.opts...)
+			if err != nil {
+				t.Fatalf("invalid options: %v", err)
+			}
+			kindCfg, err := kindConfig(cfg)
+			if err != nil {
+				t.Fatalf("kindConfig() = %v", err)
+			}
+			tt.check(t, kindCfg)
+		})
+	}
+}
+
+func TestKindConfigDoesNotModifyKindConfigOption(t *testing.T) {
+	base := v1alpha4.Cluster{ContainerdConfigPatches: []string{"# user patch"}}
+	cfg, err := newTestConfig(WithKindConfig(base))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range 2 {
+		if _, err := kindConfig(cfg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(cfg.kindConfig.ContainerdConfigPatches) != 1 {
+		t.Errorf("kind config patches = %q, want the registry patch not to accumulate", cfg.kindConfig.ContainerdConfigPatches)
+	}
+}
+
+func TestKindConfigInvalidTemplateOutput(t *testing.T) {
+	cfg, err := newTestConfig(WithConfigTemplate("kind: Cluster\nunknownField: true\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := kindConfig(cfg); err == nil {
+		t.Error("kindConfig() = nil, want an error for an unknown field")
+	}
+}
+
+func TestClusterConfigInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []ClusterOption
+	}{
+		{"negative worker nodes", []ClusterOption{WithWorkerNodes(-1)}},
+		{"empty node image", []ClusterOption{WithNodeImage("")}},
+		{"relative mount container path", []ClusterOption{WithExtraMount(".", "data")}},
+		{"missing mount host path", []ClusterOption{WithExtraMount(filepath.Join(t.TempDir(), "missing"), "/data")}},
+		{"invalid port", []ClusterOption{WithExtraPortMappings(PortMap{Host: 80, Container: 0})}},
+		{"invalid protocol", []ClusterOption{WithExtraPortMappings(PortMap{Protocol: "icmp", Host: 80, Container: 80})}},
+		{"invalid config template", []ClusterOption{WithConfigTemplate("{{ .Nodes ")}},
+		{"mirror endpoint without scheme", []ClusterOption{WithRegistryMirrors(map[string]string{"docker.io": "mirror:5000"})}},
+		{"empty feature gate", []ClusterOption{WithFeatureGates(map[string]bool{"": true})}},
+		{"empty runtime config key", []ClusterOption{WithRuntimeConfig(map[string]string{"": "true"})}},
+		{"pod subnet overlaps default service subnet", []ClusterOption{WithPodSubnet("10.96.0.0/12")}},
+		{"service subnet overlaps default pod subnet", []ClusterOption{WithServiceSubnet("10.244.128.0/24")}},
+		{"subnets overlap", []ClusterOption{WithPodSubnet("10.0.0.0/8"), WithServiceSubnet("10.1.0.0/16")}},
+		{"invalid pod subnet", []ClusterOption{WithPodSubnet("10.0.0.0")}},
+		{"invalid service subnet", []ClusterOption{WithServiceSubnet("not a cidr")}},
+		{"invalid IP family", []ClusterOption{WithIPFamily("ipv5")}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := newTestConfig(tt.opts...); err == nil {
+				t.Error("options were accepted, want an error")
+			}
+		})
+	}
+}
+
+func TestImageNameWithDigest(t *testing.T) {
+	const digest = "sha256:0000000000000000000000000000000000000000000000000000000000000000"
+	c := &Cluster{Name: "test", registryAddr: "registry.example.com:5000"}
+
+	tests := []struct {
+		image string
+		want  string
+	}{
+		{"my-service", "registry.example.com:5000/my-service@" + digest},
+		{"my-service:latest", "registry.example.com:5000/my-service@" + digest},
+		{"team/my-service:v1", "registry.example.com:5000/team/my-service@" + digest},
+		{"my-service@sha256:1111", "registry.example.com:5000/my-service@" + digest},
+		{"my-service:v1@sha256:1111", "registry.example.com:5000/my-service@" + digest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.image, func(t *testing.T) {
+			if got := c.ImageNameWithDigest(tt.image, digest); got != tt.want {
+				t.Errorf("ImageNameWithDigest(%q) = %q, want %q", tt.image, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSelectRegistryNetworks(t *testing.T) {
+	tests := []struct {
+		name     string
+		networks []string
+		want     []string
+		wantErr  bool
+	}{
+		{"kind only", []string{"kind"}, []string{"kind"}, false},
+		{"kind among others", []string{"bridge", "kind", "ci"}, []string{"kind"}, false},
+		{"custom network", []string{"my-net"}, []string{"my-net"}, false},
+		{"several custom networks", []string{"a", "b"}, []string{"a", "b"}, false},
+		{"no networks", nil, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := selectRegistryNetworks(tt.networks)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("selectRegistryNetworks() error = %v, want error %v", err, tt.wantErr)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("selectRegistryNetworks() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
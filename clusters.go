@@ -0,0 +1,154 @@
+package kubicle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"sigs.k8s.io/kind/pkg/cluster"
+)
+
+// clusterLabel is set on containers kubicle creates for a cluster, such as
+// its registry, with the cluster's name as value.
+const clusterLabel = "kubicle.cluster"
+
+// ClusterInfo describes an existing kind cluster.
+type ClusterInfo struct {
+	Name string
+	// RegistryExists reports whether the cluster's <name>-registry container
+	// exists. It is false for kind clusters kubicle did not create.
+	RegistryExists bool
+}
+
+// ListClusters returns the names of all kind clusters on the local Docker
+// daemon, whether or not they were created by kubicle.
+func ListClusters(ctx context.Context) ([]string, error) {
+	err := pingDocker(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	provider := cluster.NewProvider(
+		cluster.ProviderWithDocker(),
+	)
+	clusters, err := provider.List()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list clusters: %w", err)
+	}
+	return clusters, nil
+}
+
+// ListClusterInfo is like ListClusters but also reports whether each cluster
+// has a registry container, which helps to find clusters left behind by
+// crashed test runs.
+func ListClusterInfo(ctx context.Context) ([]ClusterInfo, error) {
+	clusters, err := ListClusters(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]ClusterInfo, 0, len(clusters))
+	for _, name := range clusters {
+		exists, err := ContainerExists(ctx, fmt.Sprintf("%s-registry", name))
+		if err != nil {
+			return nil, fmt.Errorf("failed to check registry of cluster %s: %w", name, err)
+		}
+		infos = append(infos, ClusterInfo{
+			Name:           name,
+			RegistryExists: exists,
+		})
+	}
+	return infos, nil
+}
+
+// DeleteAllClusters deletes every kind cluster on the local Docker daemon along
+// with its registry container. A failure to delete one cluster does not stop
+// the others from being deleted; all errors are returned joined.
+func DeleteAllClusters(ctx context.Context) error {
+	return DeleteClustersWithPrefix(ctx, "")
+}
+
+// DeleteClustersWithPrefix is like DeleteAllClusters but only deletes clusters
+// whose name starts with prefix. Registry containers whose cluster no longer
+// exists, e.g. because it was deleted with the kind CLI, are removed as well.
+func DeleteClustersWithPrefix(ctx context.Context, prefix string) error {
+	clusters, err := ListClusters(ctx)
+	if err != nil {
+		return err
+	}
+	labeled, err := ListContainersWithLabel(ctx, clusterLabel)
+	if err != nil {
+		return err
+	}
+
+	provider := cluster.NewProvider(
+		cluster.ProviderWithDocker(),
+	)
+
+	var errs []error
+	for _, name := range clusters {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		if err := deleteCluster(ctx, provider, name); err != nil {
+			errs = append(errs, fmt.Errorf("failed to delete cluster %s: %w", name, err))
+		}
+	}
+
+	for container, clusterName := range labeled {
+		if !strings.HasPrefix(clusterName, prefix) || slices.Contains(clusters, clusterName) {
+			continue
+		}
+		if err := RemoveContainer(ctx, container); err != nil {
+			errs = append(errs, fmt.Errorf("failed to remove orphaned container %s: %w", container, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// DeleteCluster deletes the named kind cluster along with its registry
+// container, e.g. one NewCluster reported as unhealthy. Unlike
+// DeleteClustersWithPrefix, it leaves clusters whose names merely start with
+// name alone. Deleting a cluster that does not exist is not an error.
+func DeleteCluster(ctx context.Context, name string) error {
+	provider := cluster.NewProvider(
+		cluster.ProviderWithDocker(),
+	)
+	if err := deleteCluster(ctx, provider, name); err != nil {
+		return fmt.Errorf("failed to delete cluster %s: %w", name, err)
+	}
+	return nil
+}
+
+// deleteCluster deletes the named kind cluster and its registry container, if
+// it has one that kubicle created.
+func deleteCluster(ctx context.Context, provider *cluster.Provider, name string) error {
+	registryName := fmt.Sprintf("%s-registry", name)
+	var errs []error
+
+	exists, err := ContainerExists(ctx, registryName)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("failed to check if registry container exists: %w", err))
+	} else if exists {
+		// Leave a container that merely has the registry's name alone.
+		err := checkRegistryOwner(ctx, registryName, name)
+		if err != nil && !errors.Is(err, ErrRegistryNameConflict) {
+			errs = append(errs, err)
+		} else if err == nil {
+			// The port is only needed to forget the registry's CA, so a
+			// registry that was never published is not an error.
+			port, _ := GetContainerHostPort(ctx, registryName, registryContainerPort, "tcp")
+			if err := removeRegistry(ctx, name, port); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	if err := provider.Delete(name, ""); err != nil {
+		errs = append(errs, fmt.Errorf("failed to delete cluster: %w", err))
+	}
+
+	return errors.Join(errs...)
+}
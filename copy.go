@@ -0,0 +1,173 @@
+package kubicle
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// CopyToPod copies localPath, a file or directory on the host, to remotePath
+// in a container of a running pod, like kubectl cp. A directory's contents
+// end up in remotePath, which is created if needed; a file's parent directory
+// must exist. The container must have tar. The container name may be empty
+// for pods with a single container.
+func (c *Cluster) CopyToPod(ctx context.Context, namespace, pod, container, localPath, remotePath string) error {
+	info, err := os.Stat(localPath)
+	if err != nil {
+		return fmt.Errorf("failed to copy to pod: %w", err)
+	}
+
+	var (
+		archive io.Reader
+		dir     string
+	)
+	if info.IsDir() {
+		dir = remotePath
+		_, stderr, err := c.Exec(ctx, namespace, pod, container, []string{"mkdir", "-p", dir})
+		if err != nil {
+			return fmt.Errorf("failed to create %s in pod %s/%s: %w: %s", dir, namespace, pod, err, stderr)
+		}
+		archive = tarFS(os.DirFS(localPath), ".")
+	} else {
+		dir = path.Dir(remotePath)
+		archive, err = tarFile(localPath, path.Base(remotePath), info)
+		if err != nil {
+			return err
+		}
+	}
+
+	var stderr bytes.Buffer
+	err = c.exec(ctx, namespace, pod, container, []string{"tar", "-xmf", "-", "-C", dir}, archive, io.Discard, &stderr)
+	if err != nil {
+		return fmt.Errorf("failed to copy %s to pod %s/%s: %w: %s", localPath, namespace, pod, err, stderr.String())
+	}
+	return nil
+}
+
+// tarFile returns a tar archive holding the file at filePath under name.
+func tarFile(filePath, name string, info fs.FileInfo) (io.Reader, error) {
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", filePath, err)
+	}
+	header, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create tar header: %w", err)
+	}
+	header.Name = name
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	if err := tw.WriteHeader(header); err != nil {
+		return nil, fmt.Errorf("failed to write tar header: %w", err)
+	}
+	if _, err := tw.Write(content); err != nil {
+		return nil, fmt.Errorf("failed to write tar content: %w", err)
+	}
+	if err := tw.Close(); err != nil {
+		return nil, fmt.Errorf("failed to close tar writer: %w", err)
+	}
+	return &buf, nil
+}
+
+// CopyFromPod copies remotePath, a file or directory in a container of a
+// running pod, to localPath on the host, like kubectl cp. A directory's
+// contents end up in localPath, which is created if needed. Only regular
+// files and directories are copied; symlinks and other special files are
+// skipped. The container must have tar. The container name may be empty for
+// pods with a single container.
+func (c *Cluster) CopyFromPod(ctx context.Context, namespace, pod, container, remotePath, localPath string) error {
+	remotePath = path.Clean(remotePath)
+	base := path.Base(remotePath)
+
+	pr, pw := io.Pipe()
+	var stderr bytes.Buffer
+	execErr := make(chan error, 1)
+	go func() {
+		err := c.exec(ctx, namespace, pod, container, []string{"tar", "-cf", "-", "-C", path.Dir(remotePath), base}, nil, pw, &stderr)
+		pw.CloseWithError(err)
+		execErr <- err
+	}()
+
+	err := extractTar(pr, base, localPath)
+	if err == nil {
+		// Read the padding after the end of the archive, so tar can exit.
+		_, err = io.Copy(io.Discard, pr)
+	}
+	// Unblock the exec if extraction stopped early, then wait for it, so
+	// stderr is complete and a tar that failed after writing the end of the
+	// archive is not taken for success.
+	pr.Close()
+	if waitErr := <-execErr; err == nil {
+		err = waitErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to copy %s from pod %s/%s: %w: %s", remotePath, namespace, pod, err, stderr.String())
+	}
+	return nil
+}
+
+// extractTar writes the entries of the tar stream r below root, the name of
+// the copied file or directory in the archive, to localPath.
+func extractTar(r io.Reader, root, localPath string) error {
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		// Don't let a crafted archive write outside localPath.
+		name := path.Clean(header.Name)
+		if !filepath.IsLocal(filepath.FromSlash(name)) {
+			return fmt.Errorf("archive entry %q escapes destination", header.Name)
+		}
+		var rel string
+		switch {
+		case name == root:
+		case strings.HasPrefix(name, root+"/"):
+			rel = strings.TrimPrefix(name, root+"/")
+		default:
+			continue
+		}
+		target := filepath.Join(localPath, filepath.FromSlash(rel))
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0o755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+				return err
+			}
+			if err := writeFile(target, tr, header.FileInfo().Mode().Perm()); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// writeFile writes the content of r to a file at name.
+func writeFile(name string, r io.Reader, perm fs.FileMode) (err error) {
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err = errors.Join(err, f.Close())
+	}()
+	_, err = io.Copy(f, r)
+	return err
+}
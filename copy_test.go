@@ -0,0 +1,91 @@
+package kubicle
+
+import (
+	"archive/tar"
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testTar returns a tar archive of files, pairs of entry name and content.
+// Names ending in "/" become directories.
+func testTar(t *testing.T, files [][2]string) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, file := range files {
+		name, content := file[0], file[1]
+		header := &tar.Header{Name: name, Mode: 0o644, Typeflag: tar.TypeReg, Size: int64(len(content))}
+		if name[len(name)-1] == '/' {
+			header = &tar.Header{Name: name, Mode: 0o755, Typeflag: tar.TypeDir}
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return &buf
+}
+
+func TestExtractTar(t *testing.T) {
+	dest := filepath.Join(t.TempDir(), "out")
+	archive := testTar(t, [][2]string{
+		{"data/", ""},
+		{"data/a.txt", "a"},
+		{"data/sub/b.txt", "b"},
+		{"other/c.txt", "not copied"},
+	})
+
+	if err := extractTar(archive, "data", dest); err != nil {
+		t.Fatalf("extractTar() = %v", err)
+	}
+
+	for name, want := range map[string]string{"a.txt": "a", "sub/b.txt": "b"} {
+		got, err := os.ReadFile(filepath.Join(dest, filepath.FromSlash(name)))
+		if err != nil {
+			t.Errorf("failed to read %s: %v", name, err)
+			continue
+		}
+		if string(got) != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dest, "other")); !os.IsNotExist(err) {
+		t.Errorf("entry outside the copied directory was extracted")
+	}
+}
+
+func TestExtractTarRejectsEscapingEntries(t *testing.T) {
+	tests := []struct {
+		name  string
+		entry string
+	}{
+		{"parent directory", "../evil.txt"},
+		{"parent directory below root", "data/../../evil.txt"},
+		{"absolute", "/evil.txt"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			base := t.TempDir()
+			dest := filepath.Join(base, "out")
+			archive := testTar(t, [][2]string{
+				{"data/", ""},
+				{tt.entry, "evil"},
+			})
+
+			if err := extractTar(archive, "data", dest); err == nil {
+				t.Error("extractTar() = nil, want an error")
+			}
+			if _, err := os.Stat(filepath.Join(base, "evil.txt")); !os.IsNotExist(err) {
+				t.Error("entry was written outside the destination")
+			}
+		})
+	}
+}
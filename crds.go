@@ -0,0 +1,111 @@
+package kubicle
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/restmapper"
+)
+
+// crdEstablishedTimeout bounds how long InstallCRDs waits for the API server
+// to serve a CRD.
+const crdEstablishedTimeout = 1 * time.Minute
+
+var crdResource = schema.GroupVersionResource{
+	Group:    "apiextensions.k8s.io",
+	Version:  "v1",
+	Resource: "customresourcedefinitions",
+}
+
+// InstallCRDs applies the CustomResourceDefinitions in the manifests at paths
+// and waits until the API server serves each of them, so custom resources can
+// be created right after without no-match errors. A path may be a manifest
+// file or a directory, whose .yaml, .yml and .json files are applied in name
+// order; subdirectories are not read. Other objects in the manifests are
+// applied as well.
+func (c *Cluster) InstallCRDs(ctx context.Context, paths ...string) error {
+	var files []string
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return fmt.Errorf("failed to read CRDs: %w", err)
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return fmt.Errorf("failed to read CRDs: %w", err)
+		}
+		for _, entry := range entries {
+			switch strings.ToLower(filepath.Ext(entry.Name())) {
+			case ".yaml", ".yml", ".json":
+				if !entry.IsDir() {
+					files = append(files, filepath.Join(path, entry.Name()))
+				}
+			}
+		}
+	}
+
+	dc, err := c.DynamicClient()
+	if err != nil {
+		return err
+	}
+	mapper := restmapper.NewDeferredDiscoveryRESTMapper(memory.NewMemCacheClient(c.Discovery()))
+
+	var crds []string
+	for _, file := range files {
+		manifest, err := os.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("failed to read manifest: %w", err)
+		}
+		objs, err := decodeManifest(manifest)
+		if err != nil {
+			return fmt.Errorf("failed to decode %s: %w", file, err)
+		}
+		for _, obj := range objs {
+			if err := applyObject(ctx, dc, mapper, obj); err != nil {
+				return fmt.Errorf("failed to apply %s %q from %s: %w", obj.GetKind(), obj.GetName(), file, err)
+			}
+			gvk := obj.GroupVersionKind()
+			if gvk.Group == crdResource.Group && gvk.Kind == "CustomResourceDefinition" {
+				crds = append(crds, obj.GetName())
+			}
+		}
+	}
+
+	for _, name := range crds {
+		err := wait.PollUntilContextTimeout(ctx, waitPollInterval, crdEstablishedTimeout, true, func(ctx context.Context) (bool, error) {
+			crd, err := dc.Resource(crdResource).Get(ctx, name, metav1.GetOptions{})
+			if err != nil {
+				return false, err
+			}
+			return crdEstablished(crd), nil
+		})
+		if err != nil {
+			return fmt.Errorf("failed waiting for CRD %s to be established: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// crdEstablished reports whether crd has the Established condition.
+func crdEstablished(crd *unstructured.Unstructured) bool {
+	conditions, _, _ := unstructured.NestedSlice(crd.Object, "status", "conditions")
+	return slices.ContainsFunc(conditions, func(condition any) bool {
+		c, ok := condition.(map[string]any)
+		return ok && c["type"] == "Established" && c["status"] == "True"
+	})
+}
@@ -0,0 +1,69 @@
+package kubicle
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	autoscalingv1 "k8s.io/api/autoscaling/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// restartedAtAnnotation is the pod template annotation kubectl rollout
+// restart sets to trigger a rollout.
+const restartedAtAnnotation = "kubectl.kubernetes.io/restartedAt"
+
+// ScaleDeployment sets the number of replicas of the named deployment through
+// its scale subresource. It does not wait for the new replicas; follow it with
+// WaitForDeploymentAvailable to do so.
+func (c *Cluster) ScaleDeployment(ctx context.Context, namespace, name string, replicas int32) error {
+	if replicas < 0 {
+		return fmt.Errorf("invalid replica count %d", replicas)
+	}
+
+	_, err := c.AppsV1().Deployments(namespace).UpdateScale(ctx, name, &autoscalingv1.Scale{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+		Spec: autoscalingv1.ScaleSpec{
+			Replicas: replicas,
+		},
+	}, metav1.UpdateOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to scale deployment %s/%s: %w", namespace, name, err)
+	}
+	return nil
+}
+
+// RolloutRestart restarts the pods of the named deployment like kubectl
+// rollout restart, e.g. so they pull an image that was pushed again under the
+// same tag. The image must be pulled on every start for that, i.e. with the
+// tag "latest" or imagePullPolicy Always. It does not wait for the rollout;
+// follow it with WaitForDeploymentAvailable to do so.
+func (c *Cluster) RolloutRestart(ctx context.Context, namespace, name string) error {
+	patch, err := json.Marshal(map[string]any{
+		"spec": map[string]any{
+			"template": map[string]any{
+				"metadata": map[string]any{
+					"annotations": map[string]string{
+						restartedAtAnnotation: time.Now().Format(time.RFC3339),
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode restart patch: %w", err)
+	}
+
+	_, err = c.AppsV1().Deployments(namespace).Patch(ctx, name, types.StrategicMergePatchType, patch, metav1.PatchOptions{
+		FieldManager: fieldManager,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to restart deployment %s/%s: %w", namespace, name, err)
+	}
+	return nil
+}
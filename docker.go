@@ -2,25 +2,47 @@ package kubicle
 
 import (
 	"archive/tar"
+	"bytes"
 	"context"
-	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/fs"
+	"maps"
+	"net"
+	"net/netip"
 	"os"
+	"path"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/build"
 	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
 	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/api/types/network"
 	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/jsonmessage"
+	"github.com/docker/docker/pkg/stdcopy"
 	"github.com/docker/go-connections/nat"
 )
 
+// ErrDockerUnavailable is returned when the Docker daemon cannot be reached,
+// e.g. because it is not running. Integration tests can check for it with
+// errors.Is to skip rather than fail.
+var ErrDockerUnavailable = errors.New("docker is unavailable")
+
+// ErrDockerfileNotFound is returned when building from a directory or fs.FS
+// that does not contain the configured Dockerfile. The error names the path
+// that was expected, relative to the build context.
+var ErrDockerfileNotFound = errors.New("dockerfile not found in build context")
+
 var (
 	_client     *client.Client
 	_clientOnce sync.Once
@@ -29,63 +51,266 @@ var (
 
 func getClient() (*client.Client, error) {
 	_clientOnce.Do(func() {
-		cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
-		if err != nil {
-			_clientErr = fmt.Errorf("failed to create docker client: %w", err)
-			return
-		}
-		_client = cli
+		_client, _clientErr = newClient()
 	})
 	return _client, _clientErr
 }
 
+// newClient creates a Docker client configured from the environment, e.g.
+// DOCKER_HOST, like the docker CLI.
+func newClient() (*client.Client, error) {
+	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to create docker client: %w", ErrDockerUnavailable, err)
+	}
+	return cli, nil
+}
+
+// pingDocker checks that the Docker daemon is reachable.
+func pingDocker(ctx context.Context) error {
+	cli, err := getClient()
+	if err != nil {
+		return err
+	}
+	return pingClient(ctx, cli)
+}
+
+// pingClient checks that the Docker daemon cli talks to is reachable.
+func pingClient(ctx context.Context, cli *client.Client) error {
+	_, err := cli.Ping(ctx)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrDockerUnavailable, err)
+	}
+	return nil
+}
+
 // PullImage pulls a Docker image by name from a remote registry.
-func PullImage(ctx context.Context, name string) error {
+// If auths holds credentials for the image's registry, they are used.
+func PullImage(ctx context.Context, name string, auths ...RegistryAuth) error {
 	cli, err := getClient()
 	if err != nil {
 		return err
 	}
 
-	reader, err := cli.ImagePull(ctx, name, image.PullOptions{})
+	registryAuth, err := encodeAuthForImage(name, auths)
+	if err != nil {
+		return err
+	}
+
+	reader, err := cli.ImagePull(ctx, name, image.PullOptions{
+		RegistryAuth: registryAuth,
+	})
 	if err != nil {
 		return fmt.Errorf("failed to pull image: %w", err)
 	}
 	defer reader.Close()
 
-	// Consume the response body to ensure the request completes
-	_, err = io.Copy(io.Discard, reader)
+	// Consume the response to finish the request. Like a push, a failed pull
+	// is only reported in the message stream.
+	err = decodeJSONMessages(reader, nil)
 	if err != nil {
 		return fmt.Errorf("failed to read image pull response: %w", err)
 	}
 	return nil
 }
 
+// BuildOptions customizes how an image is built from its build context.
+// The zero value builds the Dockerfile at the root of the context.
+type BuildOptions struct {
+	// Dockerfile is the path to the Dockerfile, relative to the root of the
+	// build context. Defaults to "Dockerfile".
+	Dockerfile string
+	// Target is the build stage to stop at in a multi-stage Dockerfile.
+	Target string
+	// BuildArgs are passed to the build as --build-arg values.
+	BuildArgs map[string]*string
+	// Output, if set, receives the build log as it is streamed from the
+	// Docker daemon. When nil, the daemon is asked to suppress build output.
+	Output io.Writer
+	// RegistryAuths are the credentials the daemon may use to pull base
+	// images. Each registry's credentials are only sent to that registry.
+	// Credentials from the docker CLI's config, in $DOCKER_CONFIG or
+	// ~/.docker, are used as well; RegistryAuths take precedence over them.
+	// BuildKit builds are served the same credentials through their session.
+	RegistryAuths []RegistryAuth
+	// Platform is the platform to build for, e.g. "linux/amd64". Defaults to
+	// the platform of the Docker daemon.
+	Platform string
+	// Platforms, if set, builds the image once for each platform and pushes
+	// a manifest list referencing all of them, so nodes of different
+	// architectures pull the matching image. It takes precedence over
+	// Platform and is only supported by the cluster's build and push methods.
+	// Building for a foreign architecture requires emulation to be set up for
+	// the Docker daemon, e.g. with binfmt.
+	Platforms []string
+	// DryRun builds the image to surface Dockerfile and build context errors,
+	// then removes it again. The cluster's build and push methods skip the
+	// push and report no digest. Layers stay in the build cache, so a
+	// following real build is fast.
+	DryRun bool
+	// NoCache builds every step afresh instead of reusing cached layers.
+	NoCache bool
+	// PullParent pulls the base images even if they are present locally, so
+	// a changed upstream image is picked up.
+	PullParent bool
+	// BuildKit builds with BuildKit instead of the classic builder. With
+	// BuildKit, Output receives the output of each step rather than the
+	// classic build log.
+	BuildKit bool
+	// Secrets are made available to RUN --mount=type=secret instructions.
+	// Setting them implies BuildKit.
+	Secrets []BuildSecret
+	// Timeout, if set, bounds each build attempt independently of ctx. A
+	// build that runs longer, e.g. one stuck in a RUN instruction, is
+	// cancelled and fails with an error matching context.DeadlineExceeded.
+	// Pushing the built image is not covered.
+	Timeout time.Duration
+	// Tags are further tags of the image's repository, e.g. "v1.2.3", under
+	// which the image is pushed besides the given image name, e.g.
+	// "my-service:latest". BuildImageWithOptions ignores them.
+	Tags []string
+
+	// progress, if set, receives every message the build and the following
+	// push report, see Cluster.BuildAndPushImageWithProgress.
+	progress func(ProgressPhase, jsonmessage.JSONMessage) error
+}
+
+// progressHandler returns a handler forwarding messages of phase to
+// o.progress, or nil if no progress is requested.
+func (o BuildOptions) progressHandler(phase ProgressPhase) func(jsonmessage.JSONMessage) error {
+	if o.progress == nil {
+		return nil
+	}
+	return func(msg jsonmessage.JSONMessage) error {
+		return o.progress(phase, msg)
+	}
+}
+
 // BuildImage builds a Docker image from the given tar archive build context.
 func BuildImage(ctx context.Context, name string, contextTarBall io.Reader) error {
+	return BuildImageWithOptions(ctx, name, contextTarBall, BuildOptions{})
+}
+
+// BuildImageWithOptions builds a Docker image from the given tar archive build
+// context, using opts to select the Dockerfile, target stage and build args.
+func BuildImageWithOptions(ctx context.Context, name string, contextTarBall io.Reader, opts BuildOptions) error {
 	cli, err := getClient()
 	if err != nil {
 		return err
 	}
 
-	buildResp, err := cli.ImageBuild(ctx, contextTarBall, types.ImageBuildOptions{
+	if opts.Timeout > 0 {
+		// Cancelling the request makes the daemon abort the build.
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeoutCause(ctx, opts.Timeout,
+			fmt.Errorf("build timed out after %s: %w", opts.Timeout, context.DeadlineExceeded))
+		defer cancel()
+	}
+
+	dockerfile := opts.Dockerfile
+	if dockerfile == "" {
+		dockerfile = "Dockerfile"
+	}
+
+	authConfigs := buildAuthConfigs(append(dockerConfigAuths(), opts.RegistryAuths...))
+	buildOpts := types.ImageBuildOptions{
 		Tags:           []string{name},
-		Dockerfile:     "Dockerfile",
-		SuppressOutput: true,
+		Dockerfile:     filepath.ToSlash(dockerfile),
+		Target:         opts.Target,
+		BuildArgs:      opts.BuildArgs,
+		AuthConfigs:    authConfigs,
+		Platform:       opts.Platform,
+		SuppressOutput: opts.Output == nil && opts.progress == nil,
+		NoCache:        opts.NoCache,
+		PullParent:     opts.PullParent,
 		Remove:         true,
-	})
+	}
+	buildKit := opts.BuildKit || len(opts.Secrets) > 0
+	if buildKit {
+		s, err := startBuildSession(ctx, cli, name, opts.Secrets, authConfigs)
+		if err != nil {
+			return err
+		}
+		defer s.Close()
+		buildOpts.Version = build.BuilderBuildKit
+		buildOpts.SessionID = s.ID()
+	}
+
+	buildResp, err := cli.ImageBuild(ctx, contextTarBall, buildOpts)
 	if err != nil {
-		return fmt.Errorf("failed to build image: %w", err)
+		return fmt.Errorf("failed to build image: %w", buildError(ctx, err))
 	}
 	defer buildResp.Body.Close()
 
-	// Consume the response body to ensure the build completes
-	_, err = io.Copy(io.Discard, buildResp.Body)
+	// Consume the response body to ensure the build completes. Build failures
+	// are reported in-band, so the stream has to be decoded to notice them.
+	onProgress := opts.progressHandler(ProgressPhaseBuild)
+	err = decodeJSONMessages(buildResp.Body, func(msg jsonmessage.JSONMessage) error {
+		if onProgress != nil {
+			if err := onProgress(msg); err != nil {
+				return err
+			}
+		}
+		if opts.Output != nil && buildKit {
+			return writeBuildKitLogs(opts.Output, msg)
+		}
+		if opts.Output != nil && msg.Stream != "" {
+			_, err := io.WriteString(opts.Output, msg.Stream)
+			return err
+		}
+		return nil
+	})
 	if err != nil {
-		return fmt.Errorf("failed to read image build response: %w", err)
+		return fmt.Errorf("failed to build image: %w", buildError(ctx, err))
+	}
+
+	if opts.DryRun {
+		err = DeleteImage(ctx, name)
+		if err != nil {
+			return fmt.Errorf("failed to remove dry-run image: %w", err)
+		}
 	}
 	return nil
 }
 
+// buildError returns the cause of ctx ending if it ended the build, since the
+// daemon connection then fails with an error that does not say why.
+func buildError(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return context.Cause(ctx)
+	}
+	return err
+}
+
+// decodeJSONMessages reads a Docker JSON message stream until EOF, handing each
+// message to handle. It returns an error if the stream reports one.
+func decodeJSONMessages(r io.Reader, handle func(jsonmessage.JSONMessage) error) error {
+	decoder := json.NewDecoder(r)
+	for {
+		var msg jsonmessage.JSONMessage
+		err := decoder.Decode(&msg)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to decode docker response: %w", err)
+		}
+
+		if msg.Error != nil {
+			return msg.Error
+		}
+		if msg.ErrorMessage != "" {
+			return errors.New(msg.ErrorMessage)
+		}
+
+		if handle != nil {
+			if err := handle(msg); err != nil {
+				return err
+			}
+		}
+	}
+}
+
 // GetContainerNetworks returns the names of the Docker networks a container is attached to.
 func GetContainerNetworks(ctx context.Context, containerName string) ([]string, error) {
 	cli, err := getClient()
@@ -105,6 +330,47 @@ func GetContainerNetworks(ctx context.Context, containerName string) ([]string,
 	return networks, nil
 }
 
+// GetContainerLabels returns the labels set on a container.
+func GetContainerLabels(ctx context.Context, containerName string) (map[string]string, error) {
+	cli, err := getClient()
+	if err != nil {
+		return nil, err
+	}
+
+	containerJSON, err := cli.ContainerInspect(ctx, containerName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect container: %w", err)
+	}
+	if containerJSON.Config == nil {
+		return map[string]string{}, nil
+	}
+	return containerJSON.Config.Labels, nil
+}
+
+// GetContainerHostPort returns the host port a container's port is published
+// on. It reads the configured bindings, so it works for stopped containers too.
+func GetContainerHostPort(ctx context.Context, containerName string, containerPort int, protocol string) (int, error) {
+	cli, err := getClient()
+	if err != nil {
+		return 0, err
+	}
+
+	containerJSON, err := cli.ContainerInspect(ctx, containerName)
+	if err != nil {
+		return 0, fmt.Errorf("failed to inspect container: %w", err)
+	}
+
+	port := nat.Port(fmt.Sprintf("%d/%s", containerPort, protocol))
+	for _, binding := range containerJSON.HostConfig.PortBindings[port] {
+		hostPort, err := strconv.Atoi(binding.HostPort)
+		if err != nil {
+			return 0, fmt.Errorf("failed to parse host port %q: %w", binding.HostPort, err)
+		}
+		return hostPort, nil
+	}
+	return 0, fmt.Errorf("container port %s is not published", port)
+}
+
 // PortMap describes a port mapping between a host port and a container port.
 type PortMap struct {
 	Protocol  string
@@ -112,22 +378,56 @@ type PortMap struct {
 	Container int
 }
 
+// ContainerOptions customizes a container created by CreateContainerWithOptions.
+type ContainerOptions struct {
+	// PortMappings publishes container ports on the host.
+	PortMappings []PortMap
+	// Env holds environment variables in KEY=value form.
+	Env []string
+	// Volumes mounts named Docker volumes, keyed by volume name, at the
+	// given container paths. Volumes that do not exist yet are created.
+	Volumes map[string]string
+	// Labels are set on the container, e.g. to find it again with
+	// ListContainersWithLabel.
+	Labels map[string]string
+	// RestartPolicy is one of "no", "always", "unless-stopped" or
+	// "on-failure". Defaults to "no".
+	RestartPolicy string
+}
+
 // CreateContainer creates a new Docker container with the given image and port mappings.
 // It returns the container ID on success.
 func CreateContainer(ctx context.Context, name, image string, portMappings []PortMap) (string, error) {
+	return CreateContainerWithOptions(ctx, name, image, ContainerOptions{
+		PortMappings: portMappings,
+	})
+}
+
+// CreateContainerWithOptions creates a new Docker container with the given
+// image, configured by opts. It returns the container ID on success.
+func CreateContainerWithOptions(ctx context.Context, name, image string, opts ContainerOptions) (string, error) {
 	cli, err := getClient()
 	if err != nil {
 		return "", err
 	}
 
 	containerConfig := container.Config{
-		Image: image,
+		Image:  image,
+		Env:    opts.Env,
+		Labels: opts.Labels,
 	}
 
-	var hostConfig *container.HostConfig
-	if len(portMappings) > 0 {
+	hostConfig := &container.HostConfig{
+		RestartPolicy: container.RestartPolicy{
+			Name: container.RestartPolicyMode(opts.RestartPolicy),
+		},
+	}
+	if err := container.ValidateRestartPolicy(hostConfig.RestartPolicy); err != nil {
+		return "", fmt.Errorf("invalid restart policy: %w", err)
+	}
+	if len(opts.PortMappings) > 0 {
 		portMap := make(nat.PortMap)
-		for _, pm := range portMappings {
+		for _, pm := range opts.PortMappings {
 			portMap[nat.Port(fmt.Sprintf("%d/%s", pm.Container, pm.Protocol))] = []nat.PortBinding{
 				{
 					HostIP:   "0.0.0.0",
@@ -135,9 +435,10 @@ func CreateContainer(ctx context.Context, name, image string, portMappings []Por
 				},
 			}
 		}
-		hostConfig = &container.HostConfig{
-			PortBindings: portMap,
-		}
+		hostConfig.PortBindings = portMap
+	}
+	for volume, path := range opts.Volumes {
+		hostConfig.Binds = append(hostConfig.Binds, fmt.Sprintf("%s:%s", volume, path))
 	}
 
 	id, err := cli.ContainerCreate(ctx, &containerConfig, hostConfig, nil, nil, name)
@@ -150,6 +451,10 @@ func CreateContainer(ctx context.Context, name, image string, portMappings []Por
 
 // StartContainer starts a previously created Docker container.
 func StartContainer(ctx context.Context, containerID string) error {
+	if containerID == "" {
+		return errors.New("failed to start container: container ID is empty")
+	}
+
 	cli, err := getClient()
 	if err != nil {
 		return err
@@ -162,7 +467,13 @@ func StartContainer(ctx context.Context, containerID string) error {
 	return nil
 }
 
-// WaitForContainerReady blocks until the container reports a healthy status or the timeout is reached.
+// containerPollInterval is how often the container wait helpers re-check
+// the container.
+const containerPollInterval = 250 * time.Millisecond
+
+// WaitForContainerReady blocks until the container is ready or the timeout is reached.
+// A container that defines a healthcheck is ready once it reports healthy; any
+// other container is ready once it is running.
 // If timeout is zero, it defaults to 1 minute.
 func WaitForContainerReady(ctx context.Context, timeout time.Duration, containerID string) error {
 	cli, err := getClient()
@@ -176,18 +487,135 @@ func WaitForContainerReady(ctx context.Context, timeout time.Duration, container
 	cctx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 
-	respChan, errChan := cli.ContainerWait(cctx, containerID, container.WaitConditionNotRunning)
-	select {
-	case resp := <-respChan:
-		if resp.Error != nil {
-			return fmt.Errorf("container exited with error: %s", resp.Error.Message)
+	ticker := time.NewTicker(containerPollInterval)
+	defer ticker.Stop()
+
+	for {
+		containerJSON, err := cli.ContainerInspect(cctx, containerID)
+		if err != nil {
+			return fmt.Errorf("failed to inspect container: %w", err)
+		}
+
+		state := containerJSON.State
+		switch {
+		case state == nil:
+		case state.Status == container.StateExited || state.Status == container.StateDead:
+			return fmt.Errorf("container exited with code %d: %s", state.ExitCode, state.Error)
+		case state.Health != nil:
+			switch state.Health.Status {
+			case container.Healthy:
+				return nil
+			case container.Unhealthy:
+				return errors.New("container is unhealthy")
+			}
+		case state.Running:
+			return nil
+		}
+
+		select {
+		case <-cctx.Done():
+			return fmt.Errorf("failed to wait for container: %w", cctx.Err())
+		case <-ticker.C:
 		}
-		return nil
-	case err := <-errChan:
-		return fmt.Errorf("failed to wait for container: %w", err)
 	}
 }
 
+// WaitForContainerPort blocks until the host port the container's TCP port is
+// published on accepts connections, or the timeout is reached.
+// If timeout is zero, it defaults to 1 minute.
+func WaitForContainerPort(ctx context.Context, timeout time.Duration, containerID string, containerPort int) error {
+	hostPort, err := GetContainerHostPort(ctx, containerID, containerPort, "tcp")
+	if err != nil {
+		return err
+	}
+
+	if timeout == 0 {
+		timeout = 1 * time.Minute
+	}
+	cctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	ticker := time.NewTicker(containerPollInterval)
+	defer ticker.Stop()
+
+	var dialer net.Dialer
+	address := net.JoinHostPort("localhost", strconv.Itoa(hostPort))
+	for {
+		conn, err := dialer.DialContext(cctx, "tcp", address)
+		if err == nil {
+			return conn.Close()
+		}
+
+		select {
+		case <-cctx.Done():
+			return fmt.Errorf("failed to wait for %s to accept connections: %w", address, cctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// ExecInContainer runs cmd inside a running container and returns its combined
+// output. It returns an error if the command exits with a non-zero status.
+func ExecInContainer(ctx context.Context, containerName string, cmd []string) (string, error) {
+	cli, err := getClient()
+	if err != nil {
+		return "", err
+	}
+
+	exec, err := cli.ContainerExecCreate(ctx, containerName, container.ExecOptions{
+		AttachStdout: true,
+		AttachStderr: true,
+		Cmd:          cmd,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to create exec: %w", err)
+	}
+
+	resp, err := cli.ContainerExecAttach(ctx, exec.ID, container.ExecAttachOptions{})
+	if err != nil {
+		return "", fmt.Errorf("failed to attach to exec: %w", err)
+	}
+	defer resp.Close()
+
+	var output strings.Builder
+	_, err = stdcopy.StdCopy(&output, &output, resp.Reader)
+	if err != nil {
+		return "", fmt.Errorf("failed to read exec output: %w", err)
+	}
+
+	inspect, err := cli.ContainerExecInspect(ctx, exec.ID)
+	if err != nil {
+		return "", fmt.Errorf("failed to inspect exec: %w", err)
+	}
+	if inspect.ExitCode != 0 {
+		return output.String(), fmt.Errorf("command %q exited with status %d: %s", strings.Join(cmd, " "), inspect.ExitCode, strings.TrimSpace(output.String()))
+	}
+	return output.String(), nil
+}
+
+// GetNetworkSubnets returns the subnets of a Docker network.
+func GetNetworkSubnets(ctx context.Context, networkName string) ([]netip.Prefix, error) {
+	cli, err := getClient()
+	if err != nil {
+		return nil, err
+	}
+
+	resource, err := cli.NetworkInspect(ctx, networkName, network.InspectOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect network: %w", err)
+	}
+
+	var subnets []netip.Prefix
+	for _, config := range resource.IPAM.Config {
+		subnet, err := netip.ParsePrefix(config.Subnet)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse subnet %q of network %s: %w", config.Subnet, networkName, err)
+		}
+		subnets = append(subnets, subnet)
+	}
+	return subnets, nil
+}
+
 // AttachContainerToNetwork connects a container to a Docker network.
 func AttachContainerToNetwork(ctx context.Context, containerName string, networkName string) error {
 	cli, err := getClient()
@@ -219,6 +647,127 @@ func ContainerExists(ctx context.Context, name string) (bool, error) {
 	return true, nil
 }
 
+// ContainerRunning reports whether the named container is running.
+func ContainerRunning(ctx context.Context, name string) (bool, error) {
+	cli, err := getClient()
+	if err != nil {
+		return false, err
+	}
+
+	containerJSON, err := cli.ContainerInspect(ctx, name)
+	if err != nil {
+		return false, fmt.Errorf("failed to inspect container: %w", err)
+	}
+	return containerJSON.State != nil && containerJSON.State.Running, nil
+}
+
+// copyFilesToContainer writes files, keyed by absolute path, into the named
+// container, creating parent directories as needed. The container does not
+// have to be running.
+func copyFilesToContainer(ctx context.Context, containerName string, files map[string][]byte) error {
+	cli, err := getClient()
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	dirs := map[string]bool{}
+	for name := range files {
+		name = strings.TrimPrefix(path.Clean(name), "/")
+		for dir := path.Dir(name); dir != "." && !dirs[dir]; dir = path.Dir(dir) {
+			dirs[dir] = true
+		}
+	}
+	// Sorting puts parent directories before their children.
+	for _, dir := range slices.Sorted(maps.Keys(dirs)) {
+		err := tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeDir,
+			Name:     dir + "/",
+			Mode:     0o755,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to write tar header: %w", err)
+		}
+	}
+	for name, content := range files {
+		err := tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     strings.TrimPrefix(path.Clean(name), "/"),
+			Mode:     0o644,
+			Size:     int64(len(content)),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to write tar header: %w", err)
+		}
+		if _, err := tw.Write(content); err != nil {
+			return fmt.Errorf("failed to write tar content: %w", err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("failed to close tar writer: %w", err)
+	}
+
+	err = cli.CopyToContainer(ctx, containerName, "/", &buf, container.CopyToContainerOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to copy files to container: %w", err)
+	}
+	return nil
+}
+
+// readContainerFile returns the content of the file at filePath in the named
+// container.
+func readContainerFile(ctx context.Context, containerName, filePath string) ([]byte, error) {
+	cli, err := getClient()
+	if err != nil {
+		return nil, err
+	}
+
+	reader, _, err := cli.CopyFromContainer(ctx, containerName, filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to copy file from container: %w", err)
+	}
+	defer reader.Close()
+
+	// The file comes wrapped in a tar archive holding just that file.
+	tr := tar.NewReader(reader)
+	if _, err := tr.Next(); err != nil {
+		return nil, fmt.Errorf("failed to read file from container: %w", err)
+	}
+	content, err := io.ReadAll(tr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file from container: %w", err)
+	}
+	return content, nil
+}
+
+// ListContainersWithLabel returns the names of all containers, running or
+// not, that have the label key, mapped to the label's value.
+func ListContainersWithLabel(ctx context.Context, key string) (map[string]string, error) {
+	cli, err := getClient()
+	if err != nil {
+		return nil, err
+	}
+
+	containers, err := cli.ContainerList(ctx, container.ListOptions{
+		All:     true,
+		Filters: filters.NewArgs(filters.Arg("label", key)),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list containers: %w", err)
+	}
+
+	result := make(map[string]string, len(containers))
+	for _, c := range containers {
+		if len(c.Names) == 0 {
+			continue
+		}
+		// The API reports names with a leading slash.
+		result[strings.TrimPrefix(c.Names[0], "/")] = c.Labels[key]
+	}
+	return result, nil
+}
+
 // RemoveContainer force-removes a Docker container.
 func RemoveContainer(ctx context.Context, containerID string) error {
 	cli, err := getClient()
@@ -235,37 +784,74 @@ func RemoveContainer(ctx context.Context, containerID string) error {
 	return nil
 }
 
-// PushImage pushes a Docker image to its registry.
-func PushImage(ctx context.Context, name string) error {
+// RemoveVolume removes a named Docker volume. Removing a volume that does not
+// exist is not an error.
+func RemoveVolume(ctx context.Context, name string) error {
 	cli, err := getClient()
 	if err != nil {
 		return err
 	}
 
-	credentials := struct {
-		Username string `json:"username"`
-		Password string `json:"password"`
-	}{}
-	credentialsJSON, marshalErr := json.Marshal(credentials)
-	if marshalErr != nil {
-		return fmt.Errorf("failed to marshal credentials: %w", marshalErr)
+	err = cli.VolumeRemove(ctx, name, false)
+	if err != nil && !client.IsErrNotFound(err) {
+		return fmt.Errorf("failed to remove volume: %w", err)
+	}
+	return nil
+}
+
+// PushImage pushes a Docker image to its registry.
+// If auths holds credentials for the image's registry, they are used.
+func PushImage(ctx context.Context, name string, auths ...RegistryAuth) error {
+	_, err := pushImage(ctx, name, auths, nil)
+	return err
+}
+
+// pushImage pushes a Docker image to its registry, authenticating with auths
+// if they hold credentials for it, and returns the digest of the pushed
+// manifest. If onMessage is not nil, it is called with every message the push
+// reports.
+func pushImage(ctx context.Context, name string, auths []RegistryAuth, onMessage func(jsonmessage.JSONMessage) error) (string, error) {
+	cli, err := getClient()
+	if err != nil {
+		return "", err
+	}
+
+	registryAuth, err := encodeAuthForImage(name, auths)
+	if err != nil {
+		return "", err
 	}
-	credentialsJSONBase64 := base64.StdEncoding.EncodeToString(credentialsJSON)
 
 	reader, err := cli.ImagePush(ctx, name, image.PushOptions{
-		RegistryAuth: credentialsJSONBase64,
+		RegistryAuth: registryAuth,
 	})
 	if err != nil {
-		return fmt.Errorf("failed to push image: %w", err)
+		return "", fmt.Errorf("failed to push image: %w", err)
 	}
 	defer reader.Close()
 
-	// Consume the push response to finish the request
-	_, err = io.Copy(io.Discard, reader)
+	// Consume the push response to finish the request. The digest of the
+	// pushed manifest is reported in an aux message at the end.
+	var digest string
+	err = decodeJSONMessages(reader, func(msg jsonmessage.JSONMessage) error {
+		if onMessage != nil {
+			if err := onMessage(msg); err != nil {
+				return err
+			}
+		}
+		if msg.Aux == nil {
+			return nil
+		}
+		var result types.PushResult
+		if err := json.Unmarshal(*msg.Aux, &result); err != nil {
+			return fmt.Errorf("failed to decode push result: %w", err)
+		}
+		digest = result.Digest
+		return nil
+	})
 	if err != nil {
-		return fmt.Errorf("failed to read image push response: %w", err)
+		return "", fmt.Errorf("failed to read image push response: %w", err)
 	}
-	return nil
+	return digest, nil
 }
 
 // DeleteImage force-removes a Docker image by name.
@@ -284,35 +870,216 @@ func DeleteImage(ctx context.Context, name string) error {
 	return nil
 }
 
+// TagImage adds the tag target to the local image source.
+func TagImage(ctx context.Context, source, target string) error {
+	cli, err := getClient()
+	if err != nil {
+		return err
+	}
+
+	err = cli.ImageTag(ctx, source, target)
+	if err != nil {
+		return fmt.Errorf("failed to tag image: %w", err)
+	}
+	return nil
+}
+
+// saveImage writes the named images from the local Docker daemon to w as a
+// tar archive in the format produced by docker save.
+func saveImage(ctx context.Context, w io.Writer, names ...string) error {
+	cli, err := getClient()
+	if err != nil {
+		return err
+	}
+
+	reader, err := cli.ImageSave(ctx, names)
+	if err != nil {
+		return fmt.Errorf("failed to save image: %w", err)
+	}
+	defer reader.Close()
+
+	_, err = io.Copy(w, reader)
+	if err != nil {
+		return fmt.Errorf("failed to read image save response: %w", err)
+	}
+	return nil
+}
+
+// SaveImage writes imageName from the local Docker daemon to w as a tar
+// archive, like docker save, e.g. to move it to an air-gapped machine. The
+// archive can be loaded with LoadImageFromTar.
+func SaveImage(ctx context.Context, imageName string, w io.Writer) error {
+	return saveImage(ctx, w, imageName)
+}
+
+// LoadImageFromTar loads the images in r, a tar archive produced by SaveImage
+// or docker save, into the local Docker daemon, like docker load. Use
+// Cluster.LoadImage or Cluster.PushLocalImage to get them into a cluster.
+func LoadImageFromTar(ctx context.Context, r io.Reader) error {
+	cli, err := getClient()
+	if err != nil {
+		return err
+	}
+
+	resp, err := cli.ImageLoad(ctx, r)
+	if err != nil {
+		return fmt.Errorf("failed to load image: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// Like builds, loads report failures in-band.
+	if resp.JSON {
+		err = decodeJSONMessages(resp.Body, nil)
+	} else {
+		_, err = io.Copy(io.Discard, resp.Body)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read image load response: %w", err)
+	}
+	return nil
+}
+
 // PushImageToClusterRegistry builds a Docker image from contextDir, pushes it
 // to the local cluster registry at localhost:5000, and cleans up the local copy.
+//
+// The push goes through the registry's published host port because the
+// in-network name (<cluster>-registry:5000) only resolves inside the cluster's
+// Docker network. Both addresses reach the same registry, so the pushed image
+// is available to pods as Cluster.ImageName(imageName).
 func PushImageToClusterRegistry(ctx context.Context, imageName, contextDir string) error {
-	contextTarball, err := tarDirectory(contextDir)
+	return PushImageToClusterRegistryWithOptions(ctx, imageName, contextDir, BuildOptions{})
+}
+
+// PushImageToClusterRegistryWithOptions is like PushImageToClusterRegistry but
+// builds the image using opts.
+func PushImageToClusterRegistryWithOptions(ctx context.Context, imageName, contextDir string, opts BuildOptions) error {
+	newContext := func() (io.Reader, error) {
+		return tarDirectory(contextDir, opts.Dockerfile)
+	}
+
+	registryHost := fmt.Sprintf("localhost:%d", defaultRegistryPort)
+	_, err := pushImageToRegistry(ctx, registryHost, imageName, newContext, opts, retryPolicy{})
+	return err
+}
+
+// pushImageToRegistry builds an image from the build context tarball returned
+// by newContext, pushes it to the registry at registryHost, under imageName and
+// each of opts.Tags, and removes the local copies. It returns the built
+// image's details and the digest of the pushed manifest. The build and the
+// pushes are each retried according to retry; newContext is called again for
+// every build attempt.
+func pushImageToRegistry(ctx context.Context, registryHost, imageName string, newContext func() (io.Reader, error), opts BuildOptions, retry retryPolicy) (BuildResult, error) {
+	names, err := registryImageNames(registryHost, imageName, opts.Tags)
 	if err != nil {
-		return fmt.Errorf("failed to create tarball: %w", err)
+		return BuildResult{}, err
 	}
+	registryImage := names[0]
 
-	registryImage := fmt.Sprintf("localhost:5000/%s", imageName)
+	err = retry.do(ctx, func() error {
+		contextTarball, err := newContext()
+		if err != nil {
+			return fmt.Errorf("failed to create tarball: %w", err)
+		}
+		return BuildImageWithOptions(ctx, registryImage, contextTarball, opts)
+	})
+	if err != nil {
+		return BuildResult{}, fmt.Errorf("failed to build image: %w", err)
+	}
+	if opts.DryRun {
+		return BuildResult{}, nil
+	}
 
-	err = BuildImage(ctx, registryImage, contextTarball)
+	result, err := inspectImage(ctx, registryImage)
 	if err != nil {
-		return fmt.Errorf("failed to build image: %w", err)
+		return BuildResult{}, err
 	}
 
-	err = PushImage(ctx, registryImage)
+	for _, name := range names[1:] {
+		if err := TagImage(ctx, registryImage, name); err != nil {
+			return BuildResult{}, err
+		}
+	}
+
+	// Every name refers to the same image, so they share a digest.
+	for _, name := range names {
+		err = retry.do(ctx, func() error {
+			result.Digest, err = pushImage(ctx, name, nil, opts.progressHandler(ProgressPhasePush))
+			return err
+		})
+		if err != nil {
+			return BuildResult{}, fmt.Errorf("failed to push image to cluster registry: %w", err)
+		}
+	}
+
+	for _, name := range names {
+		err = DeleteImage(ctx, name)
+		if err != nil {
+			return BuildResult{}, fmt.Errorf("failed to delete image from local docker: %w", err)
+		}
+	}
+
+	return result, nil
+}
+
+// inspectImage returns the ID, size and layer count of a local image.
+func inspectImage(ctx context.Context, name string) (BuildResult, error) {
+	cli, err := getClient()
 	if err != nil {
-		return fmt.Errorf("failed to push image to cluster registry: %w", err)
+		return BuildResult{}, err
 	}
 
-	err = DeleteImage(ctx, registryImage)
+	inspect, err := cli.ImageInspect(ctx, name)
 	if err != nil {
-		return fmt.Errorf("failed to delete image from local docker: %w", err)
+		return BuildResult{}, fmt.Errorf("failed to inspect image: %w", err)
 	}
+	return BuildResult{
+		ID:     inspect.ID,
+		Size:   inspect.Size,
+		Layers: len(inspect.RootFS.Layers),
+	}, nil
+}
 
-	return nil
+// tarDirectory streams dirPath as a tar archive build context, after checking
+// that it contains dockerfile, see checkDockerfile.
+func tarDirectory(dirPath, dockerfile string) (io.Reader, error) {
+	fsys := os.DirFS(dirPath)
+	if err := checkDockerfile(fsys, dockerfile); err != nil {
+		return nil, fmt.Errorf("%s: %w", dirPath, err)
+	}
+	return tarFS(fsys, "."), nil
 }
 
-func tarDirectory(dirPath string) (io.Reader, error) {
+// checkDockerfile returns ErrDockerfileNotFound if fsys, a build context, has
+// no file at dockerfile, a path as in BuildOptions.Dockerfile. Like the
+// daemon, it accepts a lowercase "dockerfile" if none is configured. If the
+// build context itself does not exist, it says so instead.
+func checkDockerfile(fsys fs.FS, dockerfile string) error {
+	if _, err := fs.Stat(fsys, "."); err != nil {
+		return fmt.Errorf("build context not found: %w", err)
+	}
+
+	candidates := []string{"Dockerfile", "dockerfile"}
+	if dockerfile != "" {
+		candidates = []string{path.Clean(filepath.ToSlash(dockerfile))}
+	}
+
+	for _, name := range candidates {
+		_, err := fs.Stat(fsys, name)
+		if err == nil {
+			return nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) && !errors.Is(err, fs.ErrInvalid) {
+			return fmt.Errorf("failed to check for Dockerfile: %w", err)
+		}
+	}
+	return fmt.Errorf("%w: %s", ErrDockerfileNotFound, candidates[0])
+}
+
+// tarFS streams the tree rooted at root in fsys as a tar archive. Paths in the
+// archive are relative to root. Symlinks are archived as links, read through
+// fs.ReadLink, rather than followed. Errors encountered while walking fsys are
+// returned by the reader.
+func tarFS(fsys fs.FS, root string) io.Reader {
 	pr, pw := io.Pipe()
 
 	go func() {
@@ -320,15 +1087,20 @@ func tarDirectory(dirPath string) (io.Reader, error) {
 
 		var walkErr error
 		defer func() {
-			tw.Close()
+			// A failed trailer write leaves a truncated archive, so it must
+			// reach the reader just like a walk error would.
+			if err := tw.Close(); err != nil && walkErr == nil {
+				walkErr = fmt.Errorf("failed to finalize tarball: %w", err)
+			}
 			pw.CloseWithError(walkErr)
 		}()
 
-		walkErr = filepath.WalkDir(dirPath, func(path string, d fs.DirEntry, err error) error {
+		walkErr = fs.WalkDir(fsys, root, func(name string, d fs.DirEntry, err error) error {
 			if err != nil {
 				return err
 			}
-			if d.IsDir() {
+			// The root itself has no entry in the archive.
+			if name == root {
 				return nil
 			}
 
@@ -337,21 +1109,45 @@ func tarDirectory(dirPath string) (io.Reader, error) {
 				return err
 			}
 
-			header, err := tar.FileInfoHeader(fi, path)
+			var link string
+			switch {
+			case fi.Mode()&fs.ModeSymlink != 0:
+				link, err = fs.ReadLink(fsys, name)
+				if err != nil {
+					return err
+				}
+			case !fi.Mode().IsRegular() && !fi.IsDir():
+				// Sockets, devices and the like cannot be part of a build
+				// context.
+				return nil
+			}
+
+			header, err := tar.FileInfoHeader(fi, link)
 			if err != nil {
 				return err
 			}
 
-			// Remove the leading directory so paths in the tar are relative
-			relativePath := strings.TrimPrefix(path, dirPath)
-			relativePath = strings.TrimPrefix(relativePath, string(os.PathSeparator))
+			// Names from fs.WalkDir are slash-separated and relative to
+			// fsys, so the host path of the context never reaches the
+			// archive; only root itself has to be removed.
+			relativePath := name
+			if root != "." {
+				relativePath = strings.TrimPrefix(name, root+"/")
+			}
 			header.Name = relativePath
+			if fi.IsDir() {
+				// Keep directories, so empty ones survive into the build.
+				header.Name += "/"
+			}
 
 			if err := tw.WriteHeader(header); err != nil {
 				return err
 			}
+			if !fi.Mode().IsRegular() {
+				return nil
+			}
 
-			file, err := os.Open(path)
+			file, err := fsys.Open(name)
 			if err != nil {
 				return err
 			}
@@ -362,5 +1158,5 @@ func tarDirectory(dirPath string) (io.Reader, error) {
 		})
 	}()
 
-	return pr, nil
+	return pr
 }
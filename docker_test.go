@@ -0,0 +1,234 @@
+package kubicle
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"maps"
+	"slices"
+	"testing"
+	"testing/fstest"
+)
+
+// tarEntry is what readTar reports about an archive entry.
+type tarEntry struct {
+	typeflag byte
+	linkname string
+	content  string
+}
+
+// readTar reads every entry of the archive r by name.
+func readTar(t *testing.T, r io.Reader) map[string]tarEntry {
+	t.Helper()
+	entries := map[string]tarEntry{}
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return entries
+		}
+		if err != nil {
+			t.Fatalf("failed to read tar: %v", err)
+		}
+		content, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatalf("failed to read %s: %v", header.Name, err)
+		}
+		entries[header.Name] = tarEntry{
+			typeflag: header.Typeflag,
+			linkname: header.Linkname,
+			content:  string(content),
+		}
+	}
+}
+
+// testContextFS is a build context with nested files, a symlink, an empty
+// directory and a socket, which cannot be archived.
+var testContextFS = fstest.MapFS{
+	"Dockerfile":         {Data: []byte("FROM scratch\n")},
+	"app/main.go":        {Data: []byte("package main\n")},
+	"app/link":           {Data: []byte("main.go"), Mode: fs.ModeSymlink},
+	"app/empty":          {Mode: fs.ModeDir | 0o755},
+	"app/docker.sock":    {Mode: fs.ModeSocket},
+	"other/outside.txt":  {Data: []byte("not in app\n")},
+	"app/nested/dir/a.t": {Data: []byte("a\n")},
+}
+
+func TestTarFS(t *testing.T) {
+	tests := []struct {
+		name string
+		root string
+		want map[string]tarEntry
+	}{
+		{
+			name: "whole filesystem",
+			root: ".",
+			want: map[string]tarEntry{
+				"Dockerfile":         {typeflag: tar.TypeReg, content: "FROM scratch\n"},
+				"app/":               {typeflag: tar.TypeDir},
+				"app/main.go":        {typeflag: tar.TypeReg, content: "package main\n"},
+				"app/link":           {typeflag: tar.TypeSymlink, linkname: "main.go"},
+				"app/empty/":         {typeflag: tar.TypeDir},
+				"app/nested/":        {typeflag: tar.TypeDir},
+				"app/nested/dir/":    {typeflag: tar.TypeDir},
+				"app/nested/dir/a.t": {typeflag: tar.TypeReg, content: "a\n"},
+				"other/":             {typeflag: tar.TypeDir},
+				"other/outside.txt":  {typeflag: tar.TypeReg, content: "not in app\n"},
+			},
+		},
+		{
+			name: "subdirectory",
+			root: "app",
+			want: map[string]tarEntry{
+				"main.go":        {typeflag: tar.TypeReg, content: "package main\n"},
+				"link":           {typeflag: tar.TypeSymlink, linkname: "main.go"},
+				"empty/":         {typeflag: tar.TypeDir},
+				"nested/":        {typeflag: tar.TypeDir},
+				"nested/dir/":    {typeflag: tar.TypeDir},
+				"nested/dir/a.t": {typeflag: tar.TypeReg, content: "a\n"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := readTar(t, tarFS(testContextFS, tt.root))
+			checkTarEntries(t, got, tt.want)
+		})
+	}
+}
+
+// failingOpenFS is fsys with Open failing with err for the file name.
+type failingOpenFS struct {
+	fs.FS
+	name string
+	err  error
+}
+
+func (f failingOpenFS) Open(name string) (fs.File, error) {
+	if name == f.name {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: f.err}
+	}
+	return f.FS.Open(name)
+}
+
+func TestTarFSErrors(t *testing.T) {
+	errOpen := errors.New("open failed")
+	// failingOpenFS hides ReadLink, so leave symlinks out.
+	fsys := fstest.MapFS{
+		"Dockerfile":     {Data: []byte("FROM scratch\n")},
+		"app/main.go":    {Data: []byte("package main\n")},
+		"app/nested/a.t": {Data: []byte("a\n")},
+	}
+
+	tests := []struct {
+		name    string
+		fsys    fs.FS
+		root    string
+		wantErr error
+	}{
+		{"missing root", fstest.MapFS{}, "missing", fs.ErrNotExist},
+		{"failing file", failingOpenFS{FS: fsys, name: "app/main.go", err: errOpen}, ".", errOpen},
+		{"failing directory", failingOpenFS{FS: fsys, name: "app/nested", err: errOpen}, "app", errOpen},
+		{"failing root", failingOpenFS{FS: fsys, name: ".", err: errOpen}, ".", errOpen},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := io.ReadAll(tarFS(tt.fsys, tt.root))
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("reading tarball = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCheckDockerfile(t *testing.T) {
+	tests := []struct {
+		name       string
+		fsys       fs.FS
+		dockerfile string
+		wantErr    error
+	}{
+		{"default", fstest.MapFS{"Dockerfile": {}}, "", nil},
+		{"lowercase default", fstest.MapFS{"dockerfile": {}}, "", nil},
+		{"custom path", fstest.MapFS{"build/app.Dockerfile": {}}, "build/app.Dockerfile", nil},
+		{"missing", fstest.MapFS{"main.go": {}}, "", ErrDockerfileNotFound},
+		{"missing custom path", fstest.MapFS{"Dockerfile": {}}, "build/app.Dockerfile", ErrDockerfileNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkDockerfile(tt.fsys, tt.dockerfile)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("checkDockerfile() = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestTarDirectoryMissingContext(t *testing.T) {
+	_, err := tarDirectory(t.TempDir()+"/missing", "")
+	if err == nil || errors.Is(err, ErrDockerfileNotFound) {
+		t.Fatalf("tarDirectory() = %v, want a build context error", err)
+	}
+}
+
+func checkTarEntries(t *testing.T, got, want map[string]tarEntry) {
+	t.Helper()
+	if maps.Equal(got, want) {
+		return
+	}
+	t.Errorf("entries = %v\nwant %v", sortedKeys(got), sortedKeys(want))
+	for name, w := range want {
+		if got[name] != w {
+			t.Errorf("entry %s = %+v, want %+v", name, got[name], w)
+		}
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	return slices.Sorted(maps.Keys(m))
+}
+
+func TestTarFSSub(t *testing.T) {
+	sub, err := fs.Sub(testContextFS, "app/nested")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := readTar(t, tarFS(sub, "."))
+	checkTarEntries(t, got, map[string]tarEntry{
+		"dir/":    {typeflag: tar.TypeDir},
+		"dir/a.t": {typeflag: tar.TypeReg, content: "a\n"},
+	})
+}
+
+func TestPingDockerUnavailable(t *testing.T) {
+	tests := []struct {
+		name string
+		host string
+	}{
+		{"closed port", "tcp://127.0.0.1:1"},
+		{"missing socket", "unix://" + t.TempDir() + "/docker.sock"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// getClient keeps the first client it creates, so build one
+			// for this DOCKER_HOST instead.
+			t.Setenv("DOCKER_HOST", tt.host)
+			cli, err := newClient()
+			if err != nil {
+				t.Fatalf("newClient() = %v", err)
+			}
+			defer cli.Close()
+
+			err = pingClient(context.Background(), cli)
+			if !errors.Is(err, ErrDockerUnavailable) {
+				t.Errorf("pingClient() = %v, want %v", err, ErrDockerUnavailable)
+			}
+		})
+	}
+}
@@ -0,0 +1,151 @@
+package kubicle
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+// Event is a Kubernetes event, such as a FailedScheduling warning for a pod.
+type Event struct {
+	// Type is "Normal" or "Warning".
+	Type   string
+	Reason string
+	// Object is the object the event is about, as kind/name, e.g.
+	// "Pod/my-pod".
+	Object  string
+	Message string
+	// Count is how often the event occurred.
+	Count int32
+	// Time is when the event last occurred.
+	Time time.Time
+}
+
+// String formats the event like kubectl get events does.
+func (e Event) String() string {
+	return fmt.Sprintf("%s %s %s: %s", e.Type, e.Reason, e.Object, e.Message)
+}
+
+// CollectEvents returns the events in namespace that last occurred at or after
+// since, oldest first. An empty namespace collects events from all
+// namespaces.
+func (c *Cluster) CollectEvents(ctx context.Context, namespace string, since time.Time) ([]Event, error) {
+	return c.listEvents(ctx, namespace, "", since)
+}
+
+// WatchEvents streams the events in namespace as they occur, including
+// updates to repeating events, until ctx is cancelled or the API server ends
+// the watch, at which point the channel is closed. An empty namespace watches
+// all namespaces.
+func (c *Cluster) WatchEvents(ctx context.Context, namespace string) (<-chan Event, error) {
+	watcher, err := c.CoreV1().Events(namespace).Watch(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to watch events: %w", err)
+	}
+
+	events := make(chan Event)
+	go func() {
+		defer close(events)
+		defer watcher.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case e, ok := <-watcher.ResultChan():
+				if !ok {
+					return
+				}
+				if e.Type != watch.Added && e.Type != watch.Modified {
+					continue
+				}
+				event, ok := e.Object.(*corev1.Event)
+				if !ok {
+					continue
+				}
+				select {
+				case events <- newEvent(event):
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return events, nil
+}
+
+// listEvents returns the events in namespace matching fieldSelector that last
+// occurred at or after since, oldest first.
+func (c *Cluster) listEvents(ctx context.Context, namespace, fieldSelector string, since time.Time) ([]Event, error) {
+	list, err := c.CoreV1().Events(namespace).List(ctx, metav1.ListOptions{
+		FieldSelector: fieldSelector,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list events: %w", err)
+	}
+
+	var events []Event
+	for i := range list.Items {
+		event := newEvent(&list.Items[i])
+		if event.Time.Before(since) {
+			continue
+		}
+		events = append(events, event)
+	}
+	slices.SortStableFunc(events, func(a, b Event) int {
+		return a.Time.Compare(b.Time)
+	})
+	return events, nil
+}
+
+// lastWarningEvent returns the most recent warning event about the named
+// object, e.g. why a pod cannot be scheduled.
+func (c *Cluster) lastWarningEvent(ctx context.Context, namespace, kind, name string) (Event, bool) {
+	events, err := c.listEvents(ctx, namespace, fields.Set{
+		"involvedObject.kind": kind,
+		"involvedObject.name": name,
+		"type":                corev1.EventTypeWarning,
+	}.String(), time.Time{})
+	if err != nil || len(events) == 0 {
+		return Event{}, false
+	}
+	return events[len(events)-1], true
+}
+
+func newEvent(e *corev1.Event) Event {
+	count := e.Count
+	if e.Series != nil {
+		count = e.Series.Count
+	}
+	return Event{
+		Type:    e.Type,
+		Reason:  e.Reason,
+		Object:  fmt.Sprintf("%s/%s", e.InvolvedObject.Kind, e.InvolvedObject.Name),
+		Message: e.Message,
+		Count:   count,
+		Time:    eventTime(e),
+	}
+}
+
+// eventTime returns when e last occurred. Events recorded through the newer
+// events API set EventTime and leave the legacy timestamps empty, while
+// legacy events may only have FirstTimestamp if they never repeated.
+func eventTime(e *corev1.Event) time.Time {
+	switch {
+	case e.Series != nil && !e.Series.LastObservedTime.IsZero():
+		return e.Series.LastObservedTime.Time
+	case !e.EventTime.IsZero():
+		return e.EventTime.Time
+	case !e.LastTimestamp.IsZero():
+		return e.LastTimestamp.Time
+	case !e.FirstTimestamp.IsZero():
+		return e.FirstTimestamp.Time
+	default:
+		return e.CreationTimestamp.Time
+	}
+}
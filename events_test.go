@@ -0,0 +1,82 @@
+package kubicle
+
+import (
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestNewEvent(t *testing.T) {
+	base := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	at := func(minutes int) time.Time { return base.Add(time.Duration(minutes) * time.Minute) }
+
+	tests := []struct {
+		name      string
+		event     corev1.Event
+		wantTime  time.Time
+		wantCount int32
+	}{
+		{
+			name: "series",
+			event: corev1.Event{
+				EventTime:     metav1.NewMicroTime(at(1)),
+				LastTimestamp: metav1.NewTime(at(2)),
+				Count:         1,
+				Series:        &corev1.EventSeries{Count: 7, LastObservedTime: metav1.NewMicroTime(at(5))},
+			},
+			wantTime:  at(5),
+			wantCount: 7,
+		},
+		{
+			name: "event time",
+			event: corev1.Event{
+				EventTime:      metav1.NewMicroTime(at(1)),
+				LastTimestamp:  metav1.NewTime(at(2)),
+				FirstTimestamp: metav1.NewTime(at(3)),
+			},
+			wantTime: at(1),
+		},
+		{
+			name: "last timestamp",
+			event: corev1.Event{
+				LastTimestamp:  metav1.NewTime(at(2)),
+				FirstTimestamp: metav1.NewTime(at(3)),
+				Count:          3,
+			},
+			wantTime:  at(2),
+			wantCount: 3,
+		},
+		{
+			name:     "first timestamp",
+			event:    corev1.Event{FirstTimestamp: metav1.NewTime(at(3))},
+			wantTime: at(3),
+		},
+		{
+			name:     "creation timestamp",
+			event:    corev1.Event{ObjectMeta: metav1.ObjectMeta{CreationTimestamp: metav1.NewTime(at(4))}},
+			wantTime: at(4),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.event.Type = corev1.EventTypeWarning
+			tt.event.Reason = "FailedScheduling"
+			tt.event.Message = "0/1 nodes are available"
+			tt.event.InvolvedObject = corev1.ObjectReference{Kind: "Pod", Name: "web"}
+
+			got := newEvent(&tt.event)
+			if !got.Time.Equal(tt.wantTime) {
+				t.Errorf("Time = %v, want %v", got.Time, tt.wantTime)
+			}
+			if got.Count != tt.wantCount {
+				t.Errorf("Count = %d, want %d", got.Count, tt.wantCount)
+			}
+			if want := "Warning FailedScheduling Pod/web: 0/1 nodes are available"; got.String() != want {
+				t.Errorf("String() = %q, want %q", got.String(), want)
+			}
+		})
+	}
+}
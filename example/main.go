@@ -4,7 +4,6 @@ import (
 	"context"
 	"fmt"
 	"os"
-	"time"
 
 	"github.com/raphaelreyna/kubicle"
 	v1 "k8s.io/api/core/v1"
@@ -13,7 +12,7 @@ import (
 
 func main() {
 	ctx := context.Background()
-	cluster, _ := kubicle.NewCluster(ctx, "test-cluster", 5*time.Minute)
+	cluster, _ := kubicle.NewCluster(ctx, "test-cluster")
 	err := cluster.BuildAndPushImage(ctx, "my-service:latest", "./my-service")
 	if err != nil {
 		panic(fmt.Errorf("failed to make local available as image: %w", err))
@@ -0,0 +1,180 @@
+package kubicle
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/release"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/restmapper"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+const (
+	// helmNamespace is the namespace Helm releases are installed into by
+	// default.
+	helmNamespace = "default"
+	// helmTimeout bounds how long Helm waits for a release's resources to
+	// become ready or to be deleted by default.
+	helmTimeout = 5 * time.Minute
+)
+
+// HelmOptions configures InstallHelmChartWithOptions and
+// UninstallHelmChartWithOptions.
+type HelmOptions struct {
+	// Namespace is the namespace of the release. Defaults to "default".
+	Namespace string
+	// CreateNamespace creates Namespace on install if it does not exist.
+	CreateNamespace bool
+	// Timeout bounds how long to wait for the release's resources to become
+	// ready on install, or to be deleted on uninstall. If zero, it defaults
+	// to 5 minutes. A sooner deadline of ctx takes precedence.
+	Timeout time.Duration
+}
+
+func (o HelmOptions) namespace() string {
+	if o.Namespace == "" {
+		return helmNamespace
+	}
+	return o.Namespace
+}
+
+// timeout returns how long Helm may wait, bounded by ctx's deadline since
+// Helm's waits do not take a context.
+func (o HelmOptions) timeout(ctx context.Context) time.Duration {
+	timeout := o.Timeout
+	if timeout == 0 {
+		timeout = helmTimeout
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout = min(timeout, time.Until(deadline))
+	}
+	return timeout
+}
+
+// InstallHelmChart installs the chart at chartPath, a chart directory or a
+// packaged .tgz, as the release releaseName in the default namespace, with
+// values overriding the chart's defaults. It waits for the release's resources
+// to become ready and returns an error unless the release is deployed.
+func (c *Cluster) InstallHelmChart(ctx context.Context, releaseName, chartPath string, values map[string]any) error {
+	return c.InstallHelmChartWithOptions(ctx, releaseName, chartPath, values, HelmOptions{})
+}
+
+// InstallHelmChartWithOptions is like InstallHelmChart but installs the
+// release into opts.Namespace and waits up to opts.Timeout.
+func (c *Cluster) InstallHelmChartWithOptions(ctx context.Context, releaseName, chartPath string, values map[string]any, opts HelmOptions) error {
+	chrt, err := loader.Load(chartPath)
+	if err != nil {
+		return fmt.Errorf("failed to load chart %s: %w", chartPath, err)
+	}
+
+	actionConfig, err := c.helmConfig(opts.namespace())
+	if err != nil {
+		return err
+	}
+
+	install := action.NewInstall(actionConfig)
+	install.ReleaseName = releaseName
+	install.Namespace = opts.namespace()
+	install.CreateNamespace = opts.CreateNamespace
+	install.Wait = true
+	install.Timeout = opts.timeout(ctx)
+
+	rel, err := install.RunWithContext(ctx, chrt, values)
+	if err != nil {
+		return fmt.Errorf("failed to install chart %s as %s: %w", chartPath, releaseName, err)
+	}
+	if rel.Info.Status != release.StatusDeployed {
+		return fmt.Errorf("release %s is %s, not deployed", releaseName, rel.Info.Status)
+	}
+	return nil
+}
+
+// UninstallHelmChart uninstalls the release releaseName installed by
+// InstallHelmChart, deleting the resources it created.
+func (c *Cluster) UninstallHelmChart(ctx context.Context, releaseName string) error {
+	return c.UninstallHelmChartWithOptions(ctx, releaseName, HelmOptions{})
+}
+
+// UninstallHelmChartWithOptions is like UninstallHelmChart but uninstalls the
+// release from opts.Namespace and waits up to opts.Timeout for its resources
+// to be deleted.
+func (c *Cluster) UninstallHelmChartWithOptions(ctx context.Context, releaseName string, opts HelmOptions) error {
+	actionConfig, err := c.helmConfig(opts.namespace())
+	if err != nil {
+		return err
+	}
+
+	// Uninstall does not take a context, so honour cancellation up front and
+	// bound its wait by ctx's deadline.
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	uninstall := action.NewUninstall(actionConfig)
+	uninstall.Wait = true
+	uninstall.Timeout = opts.timeout(ctx)
+
+	_, err = uninstall.Run(releaseName)
+	if err != nil {
+		return fmt.Errorf("failed to uninstall release %s: %w", releaseName, err)
+	}
+	return nil
+}
+
+// helmConfig returns a Helm action configuration for releases in namespace of
+// the cluster that stores releases as secrets, like the helm CLI does.
+func (c *Cluster) helmConfig(namespace string) (*action.Configuration, error) {
+	actionConfig := &action.Configuration{}
+	err := actionConfig.Init(&restClientGetter{cluster: c, namespace: namespace}, namespace, "secret", func(format string, v ...any) {
+		c.logger.Debug(fmt.Sprintf(format, v...))
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure helm: %w", err)
+	}
+	return actionConfig, nil
+}
+
+// restClientGetter hands a cluster's client configuration to Helm.
+type restClientGetter struct {
+	cluster   *Cluster
+	namespace string
+}
+
+func (g *restClientGetter) ToRESTConfig() (*rest.Config, error) {
+	return rest.CopyConfig(g.cluster.RESTConfig), nil
+}
+
+func (g *restClientGetter) ToDiscoveryClient() (discovery.CachedDiscoveryInterface, error) {
+	dc, err := discovery.NewDiscoveryClientForConfig(g.cluster.RESTConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create discovery client: %w", err)
+	}
+	return memory.NewMemCacheClient(dc), nil
+}
+
+func (g *restClientGetter) ToRESTMapper() (meta.RESTMapper, error) {
+	dc, err := g.ToDiscoveryClient()
+	if err != nil {
+		return nil, err
+	}
+	return restmapper.NewDeferredDiscoveryRESTMapper(dc), nil
+}
+
+func (g *restClientGetter) ToRawKubeConfigLoader() clientcmd.ClientConfig {
+	config, err := clientcmd.Load([]byte(g.cluster.Kubeconfig))
+	if err != nil {
+		// The kubeconfig was parsed when the cluster was created, so this
+		// cannot happen in practice; fall back to an empty config.
+		return clientcmd.NewDefaultClientConfig(*clientcmdapi.NewConfig(), &clientcmd.ConfigOverrides{})
+	}
+	return clientcmd.NewDefaultClientConfig(*config, &clientcmd.ConfigOverrides{
+		Context: clientcmdapi.Context{Namespace: g.namespace},
+	})
+}
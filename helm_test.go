@@ -0,0 +1,87 @@
+package kubicle
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestHelmOptions(t *testing.T) {
+	soon, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	tests := []struct {
+		name          string
+		ctx           context.Context
+		opts          HelmOptions
+		wantNamespace string
+		// wantTimeout is the timeout expected, give or take a second for
+		// the time passed since soon was created.
+		wantTimeout time.Duration
+	}{
+		{
+			name:          "defaults",
+			ctx:           context.Background(),
+			wantNamespace: "default",
+			wantTimeout:   5 * time.Minute,
+		},
+		{
+			name:          "namespace and timeout",
+			ctx:           context.Background(),
+			opts:          HelmOptions{Namespace: "monitoring", Timeout: 10 * time.Minute},
+			wantNamespace: "monitoring",
+			wantTimeout:   10 * time.Minute,
+		},
+		{
+			name:          "sooner context deadline",
+			ctx:           soon,
+			opts:          HelmOptions{Timeout: 10 * time.Minute},
+			wantNamespace: "default",
+			wantTimeout:   time.Minute,
+		},
+		{
+			name:          "later context deadline",
+			ctx:           soon,
+			opts:          HelmOptions{Timeout: 30 * time.Second},
+			wantNamespace: "default",
+			wantTimeout:   30 * time.Second,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.opts.namespace(); got != tt.wantNamespace {
+				t.Errorf("namespace() = %q, want %q", got, tt.wantNamespace)
+			}
+			got := tt.opts.timeout(tt.ctx)
+			if got > tt.wantTimeout || got < tt.wantTimeout-time.Second {
+				t.Errorf("timeout() = %s, want %s", got, tt.wantTimeout)
+			}
+		})
+	}
+}
+
+func TestHelmConfigNamespace(t *testing.T) {
+	c := attachTestCluster(t, "https://127.0.0.1:1")
+	getter := &restClientGetter{cluster: c, namespace: "monitoring"}
+
+	namespace, _, err := getter.ToRawKubeConfigLoader().Namespace()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if namespace != "monitoring" {
+		t.Errorf("namespace = %q, want %q", namespace, "monitoring")
+	}
+}
+
+func TestUninstallHelmChartCancelled(t *testing.T) {
+	c := attachTestCluster(t, "https://127.0.0.1:1")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := c.UninstallHelmChartWithOptions(ctx, "release", HelmOptions{Namespace: "monitoring"})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("UninstallHelmChartWithOptions() = %v, want %v", err, context.Canceled)
+	}
+}
@@ -0,0 +1,68 @@
+package kubicle
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// ingressNginxManifestURL is the kind flavour of the ingress-nginx manifest. It
+// runs the controller on the node labelled ingress-ready=true and binds host
+// ports 80 and 443 there.
+const ingressNginxManifestURL = "https://raw.githubusercontent.com/kubernetes/ingress-nginx/controller-v1.12.1/deploy/static/provider/kind/deploy.yaml"
+
+const (
+	ingressNginxNamespace  = "ingress-nginx"
+	ingressNginxDeployment = "ingress-nginx-controller"
+)
+
+// InstallIngressNginx installs the ingress-nginx controller and waits up to
+// timeout for it to become available. If timeout is zero, it defaults to 5
+// minutes. Installing it again is a no-op.
+//
+// The controller listens on ports 80 and 443 of the control-plane node. To
+// reach it from the host, create the cluster with
+//
+//	WithExtraPortMappings(PortMap{Host: 80, Container: 80}, PortMap{Host: 443, Container: 443})
+//
+// or any other free host ports. The manifest targets kind's nodes, so an
+// attached cluster returns ErrNotKindCluster.
+func (c *Cluster) InstallIngressNginx(ctx context.Context, timeout time.Duration) error {
+	if err := c.checkKind(); err != nil {
+		return err
+	}
+	if timeout == 0 {
+		timeout = 5 * time.Minute
+	}
+
+	// The kind manifest schedules the controller onto the node carrying this
+	// label, which is the one the extra port mappings are published from.
+	nodes, err := c.CoreV1().Nodes().List(ctx, metav1.ListOptions{
+		LabelSelector: "node-role.kubernetes.io/control-plane",
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list control-plane nodes: %w", err)
+	}
+	for _, node := range nodes.Items {
+		_, err := c.CoreV1().Nodes().Patch(ctx, node.Name, types.MergePatchType,
+			[]byte(`{"metadata":{"labels":{"ingress-ready":"true"}}}`), metav1.PatchOptions{
+				FieldManager: fieldManager,
+			})
+		if err != nil {
+			return fmt.Errorf("failed to label node %s: %w", node.Name, err)
+		}
+	}
+
+	manifest, err := fetchManifest(ctx, ingressNginxManifestURL)
+	if err != nil {
+		return err
+	}
+	if err := c.ApplyManifest(ctx, manifest); err != nil {
+		return fmt.Errorf("failed to install ingress-nginx: %w", err)
+	}
+
+	return c.WaitForDeploymentAvailable(ctx, ingressNginxNamespace, ingressNginxDeployment, timeout)
+}
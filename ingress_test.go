@@ -0,0 +1,24 @@
+package kubicle
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+func TestInstallIngressNginxAttachedCluster(t *testing.T) {
+	kubeconfig, err := clientcmd.Write(*testKubeconfig(t, "attached", "https://127.0.0.1:1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := NewClusterFromKubeconfig(context.Background(), kubeconfig, "")
+	if err != nil {
+		t.Fatalf("NewClusterFromKubeconfig() = %v", err)
+	}
+
+	if err := c.InstallIngressNginx(context.Background(), 0); !errors.Is(err, ErrNotKindCluster) {
+		t.Errorf("InstallIngressNginx() = %v, want %v", err, ErrNotKindCluster)
+	}
+}
@@ -0,0 +1,102 @@
+package kubicle
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// WriteKubeconfig writes the cluster's kubeconfig to path. If merge is true
+// and path already holds a kubeconfig, the cluster's cluster, user and context
+// entries are merged into it and made the current context, leaving the other
+// entries untouched. Otherwise the file is replaced.
+func (c *Cluster) WriteKubeconfig(path string, merge bool) error {
+	config, err := clientcmd.Load([]byte(c.Kubeconfig))
+	if err != nil {
+		return fmt.Errorf("failed to parse cluster kubeconfig: %w", err)
+	}
+
+	if merge {
+		existing, err := clientcmd.LoadFromFile(path)
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			// Nothing to merge into.
+		case err != nil:
+			return fmt.Errorf("failed to load existing kubeconfig: %w", err)
+		default:
+			for name, cluster := range config.Clusters {
+				existing.Clusters[name] = cluster
+			}
+			for name, authInfo := range config.AuthInfos {
+				existing.AuthInfos[name] = authInfo
+			}
+			for name, kubeContext := range config.Contexts {
+				existing.Contexts[name] = kubeContext
+			}
+			existing.CurrentContext = config.CurrentContext
+			config = existing
+		}
+	}
+
+	err = clientcmd.WriteToFile(*config, path)
+	if err != nil {
+		return fmt.Errorf("failed to write kubeconfig: %w", err)
+	}
+	return nil
+}
+
+// KubeconfigPath writes the cluster's kubeconfig to a temporary file, for
+// tools such as kubectl or helm that expect a path. The file is written on the
+// first call and reused by later ones, and is kept up to date by Restart.
+// cleanup removes it; calling KubeconfigPath after cleanup writes a new one.
+func (c *Cluster) KubeconfigPath() (path string, cleanup func(), err error) {
+	c.kubeconfigMu.Lock()
+	defer c.kubeconfigMu.Unlock()
+
+	if c.kubeconfigPath == "" {
+		file, err := os.CreateTemp("", "kubicle-kubeconfig-*.yaml")
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to create kubeconfig file: %w", err)
+		}
+		_, err = file.WriteString(c.Kubeconfig)
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			os.Remove(file.Name())
+			return "", nil, fmt.Errorf("failed to write kubeconfig file: %w", err)
+		}
+		c.kubeconfigPath = file.Name()
+	}
+
+	path = c.kubeconfigPath
+	cleanup = func() {
+		c.kubeconfigMu.Lock()
+		defer c.kubeconfigMu.Unlock()
+
+		os.Remove(path)
+		if c.kubeconfigPath == path {
+			c.kubeconfigPath = ""
+		}
+	}
+	return path, cleanup, nil
+}
+
+// refreshKubeconfigFile rewrites the file returned by KubeconfigPath, if any,
+// after the cluster's kubeconfig changed.
+func (c *Cluster) refreshKubeconfigFile() error {
+	c.kubeconfigMu.Lock()
+	defer c.kubeconfigMu.Unlock()
+
+	if c.kubeconfigPath == "" {
+		return nil
+	}
+	err := os.WriteFile(c.kubeconfigPath, []byte(c.Kubeconfig), 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to update kubeconfig file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package kubicle
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+// testKubeconfig returns a kubeconfig with a single cluster, user and context
+// named name, pointing at server.
+func testKubeconfig(t *testing.T, name, server string) *clientcmdapi.Config {
+	t.Helper()
+	config := clientcmdapi.NewConfig()
+	config.Clusters[name] = &clientcmdapi.Cluster{Server: server}
+	config.AuthInfos[name] = &clientcmdapi.AuthInfo{Token: name + "-token"}
+	config.Contexts[name] = &clientcmdapi.Context{Cluster: name, AuthInfo: name}
+	config.CurrentContext = name
+	return config
+}
+
+func testCluster(t *testing.T, config *clientcmdapi.Config) *Cluster {
+	t.Helper()
+	kubeconfig, err := clientcmd.Write(*config)
+	if err != nil {
+		t.Fatalf("failed to encode kubeconfig: %v", err)
+	}
+	return &Cluster{Kubeconfig: string(kubeconfig)}
+}
+
+func TestWriteKubeconfig(t *testing.T) {
+	const (
+		kubiclePrevious = "https://127.0.0.1:1111"
+		kubicleServer   = "https://127.0.0.1:2222"
+		otherServer     = "https://other.example.com"
+	)
+
+	tests := []struct {
+		name     string
+		existing *clientcmdapi.Config
+		merge    bool
+		// wantContexts are the contexts the written file must have.
+		wantContexts []string
+	}{
+		{
+			name:         "merge adds context and keeps others",
+			existing:     testKubeconfig(t, "other", otherServer),
+			merge:        true,
+			wantContexts: []string{"kind-test", "other"},
+		},
+		{
+			name: "merge replaces existing kubicle context",
+			existing: func() *clientcmdapi.Config {
+				config := testKubeconfig(t, "other", otherServer)
+				previous := testKubeconfig(t, "kind-test", kubiclePrevious)
+				config.Clusters["kind-test"] = previous.Clusters["kind-test"]
+				config.AuthInfos["kind-test"] = previous.AuthInfos["kind-test"]
+				config.Contexts["kind-test"] = previous.Contexts["kind-test"]
+				return config
+			}(),
+			merge:        true,
+			wantContexts: []string{"kind-test", "other"},
+		},
+		{
+			name:         "merge without existing file",
+			merge:        true,
+			wantContexts: []string{"kind-test"},
+		},
+		{
+			name:         "no merge replaces file",
+			existing:     testKubeconfig(t, "other", otherServer),
+			wantContexts: []string{"kind-test"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config")
+			if tt.existing != nil {
+				if err := clientcmd.WriteToFile(*tt.existing, path); err != nil {
+					t.Fatalf("failed to write existing kubeconfig: %v", err)
+				}
+			}
+
+			c := testCluster(t, testKubeconfig(t, "kind-test", kubicleServer))
+			if err := c.WriteKubeconfig(path, tt.merge); err != nil {
+				t.Fatalf("WriteKubeconfig() = %v", err)
+			}
+
+			got, err := clientcmd.LoadFromFile(path)
+			if err != nil {
+				t.Fatalf("failed to load written kubeconfig: %v", err)
+			}
+			var contexts []string
+			for name := range got.Contexts {
+				contexts = append(contexts, name)
+			}
+			slices.Sort(contexts)
+			if !slices.Equal(contexts, tt.wantContexts) {
+				t.Errorf("contexts = %v, want %v", contexts, tt.wantContexts)
+			}
+			if got.CurrentContext != "kind-test" {
+				t.Errorf("current-context = %q, want %q", got.CurrentContext, "kind-test")
+			}
+			if server := got.Clusters["kind-test"].Server; server != kubicleServer {
+				t.Errorf("kind-test server = %q, want %q", server, kubicleServer)
+			}
+			if other, ok := got.Clusters["other"]; ok && other.Server != otherServer {
+				t.Errorf("other server = %q, want %q", other.Server, otherServer)
+			}
+			if other, ok := got.AuthInfos["other"]; ok && other.Token != "other-token" {
+				t.Errorf("other token = %q, want %q", other.Token, "other-token")
+			}
+		})
+	}
+}
+
+func TestWriteKubeconfigInvalidExisting(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config")
+	if err := os.WriteFile(path, []byte("{not yaml"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	c := testCluster(t, testKubeconfig(t, "kind-test", "https://127.0.0.1:2222"))
+	if err := c.WriteKubeconfig(path, true); err == nil {
+		t.Fatal("WriteKubeconfig() = nil, want error for an unparsable kubeconfig")
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "{not yaml" {
+		t.Errorf("existing kubeconfig was overwritten: %q", content)
+	}
+}
@@ -0,0 +1,70 @@
+// Package kubicletest provides helpers for using kubicle clusters in tests.
+// It is kept separate from kubicle so that importing kubicle does not pull
+// the testing package into non-test binaries.
+package kubicletest
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/raphaelreyna/kubicle"
+)
+
+// maxTestNameLength bounds the part of a cluster name taken from the test
+// name, keeping node container names within the 63 character hostname limit.
+const maxTestNameLength = 30
+
+// NewClusterForTest creates a kind cluster named after the test and registers
+// a cleanup that deletes it once the test and its subtests complete. The test
+// is skipped if Docker is unavailable and fails if the cluster cannot be
+// created. Tests that create clusters in parallel must give each one its own
+// registry port with kubicle.WithRegistryPort.
+func NewClusterForTest(tb testing.TB, opts ...kubicle.ClusterOption) *kubicle.Cluster {
+	tb.Helper()
+
+	ctx := context.Background()
+	cluster, err := kubicle.NewCluster(ctx, clusterName(tb), opts...)
+	if errors.Is(err, kubicle.ErrDockerUnavailable) {
+		tb.Skipf("skipping: %v", err)
+	}
+	if err != nil {
+		tb.Fatalf("failed to create cluster: %v", err)
+	}
+
+	tb.Cleanup(func() {
+		if err := cluster.Delete(context.Background()); err != nil {
+			tb.Errorf("failed to delete cluster %s: %v", cluster.Name, err)
+		}
+	})
+
+	return cluster
+}
+
+// clusterName derives a unique, valid kind cluster name from the test name.
+func clusterName(tb testing.TB) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(tb.Name()) {
+		if ('a' <= r && r <= 'z') || ('0' <= r && r <= '9') {
+			b.WriteRune(r)
+		} else {
+			b.WriteRune('-')
+		}
+	}
+	name := strings.Trim(b.String(), "-")
+	if len(name) > maxTestNameLength {
+		name = strings.Trim(name[:maxTestNameLength], "-")
+	}
+	if name == "" {
+		name = "test"
+	}
+
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		tb.Fatalf("failed to generate cluster name: %v", err)
+	}
+	return "kubicle-" + name + "-" + hex.EncodeToString(suffix)
+}
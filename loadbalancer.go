@@ -0,0 +1,142 @@
+package kubicle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/netip"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// metalLBManifestURL is the MetalLB release kubicle installs to serve
+// LoadBalancer services.
+const metalLBManifestURL = "https://raw.githubusercontent.com/metallb/metallb/v0.14.9/config/manifests/metallb-native.yaml"
+
+const (
+	metalLBNamespace  = "metallb-system"
+	metalLBController = "controller"
+	metalLBSpeaker    = "speaker"
+
+	// metalLBReadyTimeout bounds how long EnableLoadBalancer waits for MetalLB
+	// to come up.
+	metalLBReadyTimeout = 5 * time.Minute
+)
+
+// metalLBPoolTemplate configures MetalLB to hand out addresses from a range
+// of the cluster network and announce them over L2, which the host can reach
+// since it is attached to the same bridge.
+const metalLBPoolTemplate = `apiVersion: metallb.io/v1beta1
+kind: IPAddressPool
+metadata:
+  name: kubicle
+  namespace: metallb-system
+spec:
+  addresses:
+  - %s-%s
+---
+apiVersion: metallb.io/v1beta1
+kind: L2Advertisement
+metadata:
+  name: kubicle
+  namespace: metallb-system
+spec:
+  ipAddressPools:
+  - kubicle
+`
+
+// EnableLoadBalancer installs MetalLB so that services of type LoadBalancer
+// get an external IP. The addresses are taken from the end of the IPv4 subnet
+// of the cluster's Docker network and are reachable from the host on Linux;
+// Docker Desktop does not route to container IPs. Enabling it again is a
+// no-op.
+func (c *Cluster) EnableLoadBalancer(ctx context.Context) error {
+	if err := c.checkKind(); err != nil {
+		return err
+	}
+	first, last, err := loadBalancerRange(ctx, c.config.network())
+	if err != nil {
+		return err
+	}
+
+	manifest, err := fetchManifest(ctx, metalLBManifestURL)
+	if err != nil {
+		return err
+	}
+	if err := c.ApplyManifest(ctx, manifest); err != nil {
+		return fmt.Errorf("failed to install MetalLB: %w", err)
+	}
+	if err := c.WaitForDeploymentAvailable(ctx, metalLBNamespace, metalLBController, metalLBReadyTimeout); err != nil {
+		return err
+	}
+	if err := c.waitForDaemonSetReady(ctx, metalLBNamespace, metalLBSpeaker, metalLBReadyTimeout); err != nil {
+		return err
+	}
+
+	// The pool is validated by MetalLB's webhook, which may not be serving
+	// yet even though the controller is available.
+	pool := []byte(fmt.Sprintf(metalLBPoolTemplate, first, last))
+	var applyErr error
+	err = wait.PollUntilContextTimeout(ctx, waitPollInterval, metalLBReadyTimeout, true, func(ctx context.Context) (bool, error) {
+		applyErr = c.ApplyManifest(ctx, pool)
+		return applyErr == nil, nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to configure MetalLB address pool: %w", errors.Join(err, applyErr))
+	}
+	return nil
+}
+
+// loadBalancerRange picks the address range handed out to LoadBalancer
+// services from the top of the network's IPv4 subnet, well away from the
+// addresses Docker assigns to containers from the bottom.
+func loadBalancerRange(ctx context.Context, networkName string) (first, last netip.Addr, err error) {
+	subnets, err := GetNetworkSubnets(ctx, networkName)
+	if err != nil {
+		return netip.Addr{}, netip.Addr{}, err
+	}
+
+	first, last, ok := subnetLoadBalancerRange(subnets)
+	if !ok {
+		return netip.Addr{}, netip.Addr{}, fmt.Errorf("network %s has no IPv4 subnet large enough for load balancer addresses", networkName)
+	}
+	return first, last, nil
+}
+
+// subnetLoadBalancerRange returns the load balancer range in the first of
+// subnets that is an IPv4 subnet with at least 64 addresses, which leaves
+// room for the broadcast address and a few spare ones.
+func subnetLoadBalancerRange(subnets []netip.Prefix) (first, last netip.Addr, ok bool) {
+	for _, subnet := range subnets {
+		if !subnet.Addr().Is4() || subnet.Bits() > 26 {
+			continue
+		}
+		base := subnet.Masked().Addr().As4()
+		n := uint32(base[0])<<24 | uint32(base[1])<<16 | uint32(base[2])<<8 | uint32(base[3])
+		broadcast := n | (1<<(32-subnet.Bits()) - 1)
+		return uint32ToAddr(broadcast - 55), uint32ToAddr(broadcast - 5), true
+	}
+	return netip.Addr{}, netip.Addr{}, false
+}
+
+func uint32ToAddr(n uint32) netip.Addr {
+	return netip.AddrFrom4([4]byte{byte(n >> 24), byte(n >> 16), byte(n >> 8), byte(n)})
+}
+
+// waitForDaemonSetReady blocks until every scheduled pod of the named daemon
+// set is ready.
+func (c *Cluster) waitForDaemonSetReady(ctx context.Context, namespace, name string, timeout time.Duration) error {
+	err := wait.PollUntilContextTimeout(ctx, waitPollInterval, timeout, true, func(ctx context.Context) (bool, error) {
+		ds, err := c.AppsV1().DaemonSets(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		return ds.Status.DesiredNumberScheduled > 0 && daemonSetReady(ds), nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed waiting for daemon set %s/%s to be ready: %w", namespace, name, err)
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package kubicle
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func TestSubnetLoadBalancerRange(t *testing.T) {
+	tests := []struct {
+		name      string
+		subnets   []string
+		wantFirst string
+		wantLast  string
+		wantOK    bool
+	}{
+		{
+			name:      "kind default network",
+			subnets:   []string{"172.18.0.0/16"},
+			wantFirst: "172.18.255.200",
+			wantLast:  "172.18.255.250",
+			wantOK:    true,
+		},
+		{
+			name:      "unmasked subnet",
+			subnets:   []string{"192.168.1.17/24"},
+			wantFirst: "192.168.1.200",
+			wantLast:  "192.168.1.250",
+			wantOK:    true,
+		},
+		{
+			name:      "smallest subnet",
+			subnets:   []string{"10.0.0.64/26"},
+			wantFirst: "10.0.0.72",
+			wantLast:  "10.0.0.122",
+			wantOK:    true,
+		},
+		{
+			name:      "IPv6 subnet is skipped",
+			subnets:   []string{"fc00:f853:ccd:e793::/64", "172.19.0.0/16"},
+			wantFirst: "172.19.255.200",
+			wantLast:  "172.19.255.250",
+			wantOK:    true,
+		},
+		{
+			name:    "subnet too small",
+			subnets: []string{"10.0.0.0/27"},
+		},
+		{
+			name:    "IPv6 only",
+			subnets: []string{"fc00:f853:ccd:e793::/64"},
+		},
+		{
+			name: "no subnets",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var subnets []netip.Prefix
+			for _, subnet := range tt.subnets {
+				subnets = append(subnets, netip.MustParsePrefix(subnet))
+			}
+
+			first, last, ok := subnetLoadBalancerRange(subnets)
+			if ok != tt.wantOK {
+				t.Fatalf("subnetLoadBalancerRange() ok = %v, want %v", ok, tt.wantOK)
+			}
+			if !ok {
+				return
+			}
+			if first.String() != tt.wantFirst || last.String() != tt.wantLast {
+				t.Errorf("subnetLoadBalancerRange() = %s-%s, want %s-%s", first, last, tt.wantFirst, tt.wantLast)
+			}
+		})
+	}
+}
@@ -0,0 +1,64 @@
+package kubicle
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"sigs.k8s.io/kind/pkg/log"
+)
+
+// discardLogger is used when no logger was configured.
+var discardLogger = slog.New(slog.DiscardHandler)
+
+// kindLogger adapts an slog.Logger to the logger interface kind reports
+// cluster creation progress through. kind's V(0) messages are logged at info
+// level and its more verbose levels at debug level.
+type kindLogger struct {
+	logger *slog.Logger
+}
+
+var _ log.Logger = kindLogger{}
+
+func (l kindLogger) Warn(message string) {
+	l.logger.Warn(message)
+}
+
+func (l kindLogger) Warnf(format string, args ...any) {
+	l.logger.Warn(fmt.Sprintf(format, args...))
+}
+
+func (l kindLogger) Error(message string) {
+	l.logger.Error(message)
+}
+
+func (l kindLogger) Errorf(format string, args ...any) {
+	l.logger.Error(fmt.Sprintf(format, args...))
+}
+
+func (l kindLogger) V(level log.Level) log.InfoLogger {
+	slogLevel := slog.LevelInfo
+	if level > 0 {
+		slogLevel = slog.LevelDebug
+	}
+	return kindInfoLogger{logger: l.logger, level: slogLevel}
+}
+
+type kindInfoLogger struct {
+	logger *slog.Logger
+	level  slog.Level
+}
+
+func (l kindInfoLogger) Info(message string) {
+	l.logger.Log(context.Background(), l.level, message)
+}
+
+func (l kindInfoLogger) Infof(format string, args ...any) {
+	if l.Enabled() {
+		l.Info(fmt.Sprintf(format, args...))
+	}
+}
+
+func (l kindInfoLogger) Enabled() bool {
+	return l.logger.Enabled(context.Background(), l.level)
+}
@@ -0,0 +1,78 @@
+package kubicle
+
+import (
+	"context"
+	"log/slog"
+	"testing"
+
+	"sigs.k8s.io/kind/pkg/log"
+)
+
+// recordingHandler keeps the level and message of every record at or above
+// level.
+type recordingHandler struct {
+	level   slog.Level
+	records []slogRecord
+}
+
+type slogRecord struct {
+	level   slog.Level
+	message string
+}
+
+func (h *recordingHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level
+}
+
+func (h *recordingHandler) Handle(_ context.Context, r slog.Record) error {
+	h.records = append(h.records, slogRecord{level: r.Level, message: r.Message})
+	return nil
+}
+
+func (h *recordingHandler) WithAttrs([]slog.Attr) slog.Handler { return h }
+
+func (h *recordingHandler) WithGroup(string) slog.Handler { return h }
+
+func TestKindLogger(t *testing.T) {
+	tests := []struct {
+		name string
+		log  func(log.Logger)
+		want slogRecord
+	}{
+		{"warn", func(l log.Logger) { l.Warn("warn") }, slogRecord{slog.LevelWarn, "warn"}},
+		{"warnf", func(l log.Logger) { l.Warnf("warn %d", 1) }, slogRecord{slog.LevelWarn, "warn 1"}},
+		{"error", func(l log.Logger) { l.Error("error") }, slogRecord{slog.LevelError, "error"}},
+		{"errorf", func(l log.Logger) { l.Errorf("error %d", 1) }, slogRecord{slog.LevelError, "error 1"}},
+		{"V(0) info", func(l log.Logger) { l.V(0).Info("info") }, slogRecord{slog.LevelInfo, "info"}},
+		{"V(0) infof", func(l log.Logger) { l.V(0).Infof("info %d", 1) }, slogRecord{slog.LevelInfo, "info 1"}},
+		{"V(1) info", func(l log.Logger) { l.V(1).Info("debug") }, slogRecord{slog.LevelDebug, "debug"}},
+		{"V(3) infof", func(l log.Logger) { l.V(3).Infof("debug %d", 3) }, slogRecord{slog.LevelDebug, "debug 3"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &recordingHandler{level: slog.LevelDebug}
+			tt.log(kindLogger{logger: slog.New(h)})
+			if len(h.records) != 1 || h.records[0] != tt.want {
+				t.Errorf("records = %+v, want [%+v]", h.records, tt.want)
+			}
+		})
+	}
+}
+
+func TestKindLoggerEnabled(t *testing.T) {
+	h := &recordingHandler{level: slog.LevelInfo}
+	l := kindLogger{logger: slog.New(h)}
+
+	if !l.V(0).Enabled() {
+		t.Error("V(0).Enabled() = false at info level, want true")
+	}
+	if l.V(1).Enabled() {
+		t.Error("V(1).Enabled() = true at info level, want false")
+	}
+	l.V(1).Info("debug")
+	l.V(2).Infof("debug %d", 2)
+	if len(h.records) != 0 {
+		t.Errorf("records = %+v, want debug messages dropped", h.records)
+	}
+}
@@ -0,0 +1,129 @@
+package kubicle
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const (
+	dockerManifestMediaType     = "application/vnd.docker.distribution.manifest.v2+json"
+	dockerManifestListMediaType = "application/vnd.docker.distribution.manifest.list.v2+json"
+	ociIndexMediaType           = "application/vnd.oci.image.index.v1+json"
+)
+
+// manifestList is a Docker manifest list or OCI image index.
+type manifestList struct {
+	SchemaVersion int                    `json:"schemaVersion"`
+	MediaType     string                 `json:"mediaType"`
+	Manifests     []manifestListManifest `json:"manifests"`
+}
+
+type manifestListManifest struct {
+	manifestDescriptor
+	Platform manifestListPlatform `json:"platform"`
+}
+
+type manifestListPlatform struct {
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+	Variant      string `json:"variant,omitempty"`
+}
+
+// pushMultiPlatformImage builds the image once for each of opts.Platforms and
+// pushes each build under its own tag, e.g. my-service:latest-linux-arm64. It
+// then pushes a manifest list referencing them as imageName, and under each of
+// opts.Tags, and returns the list's digest.
+func (c *Cluster) pushMultiPlatformImage(ctx context.Context, imageName string, newContext func() (io.Reader, error), opts BuildOptions) (string, error) {
+	registryHost := c.HostRegistryAddress()
+	repository, ref, err := parseRegistryImage(registryHost, imageName)
+	if err != nil {
+		return "", err
+	}
+	if strings.Contains(ref, ":") {
+		return "", fmt.Errorf("multi-platform image %s must be referenced by tag, not digest", imageName)
+	}
+	// Reject invalid tags before building anything.
+	if _, err := registryImageNames(registryHost, imageName, opts.Tags); err != nil {
+		return "", err
+	}
+
+	list := manifestList{
+		SchemaVersion: 2,
+		MediaType:     dockerManifestListMediaType,
+	}
+	for _, platform := range opts.Platforms {
+		platformOS, platformArch, platformVariant, err := splitPlatform(platform)
+		if err != nil {
+			return "", err
+		}
+
+		platformOpts := opts
+		platformOpts.Platforms = nil
+		platformOpts.Tags = nil
+		platformOpts.Platform = platform
+		platformImage := fmt.Sprintf("%s:%s-%s", repository, ref, strings.ReplaceAll(platform, "/", "-"))
+
+		result, err := c.pushImageToRegistry(ctx, platformImage, newContext, platformOpts, c.config.retry)
+		if err != nil {
+			return "", fmt.Errorf("failed to build image for %s: %w", platform, err)
+		}
+		if opts.DryRun {
+			continue
+		}
+
+		desc, found, err := registryManifest(ctx, registryHost, repository, result.Digest)
+		if err != nil {
+			return "", fmt.Errorf("failed to resolve image for %s: %w", platform, err)
+		}
+		if !found {
+			return "", fmt.Errorf("image for %s not found in registry after push", platform)
+		}
+		// A docker manifest list may only reference docker manifests; the
+		// OCI index is used as soon as anything else, e.g. an OCI manifest
+		// pushed by the containerd image store, is involved.
+		if desc.MediaType != dockerManifestMediaType {
+			list.MediaType = ociIndexMediaType
+		}
+		list.Manifests = append(list.Manifests, manifestListManifest{
+			manifestDescriptor: desc,
+			Platform: manifestListPlatform{
+				Architecture: platformArch,
+				OS:           platformOS,
+				Variant:      platformVariant,
+			},
+		})
+	}
+	if opts.DryRun {
+		return "", nil
+	}
+
+	body, err := json.Marshal(list)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode manifest list: %w", err)
+	}
+	var digest string
+	for _, tag := range append([]string{ref}, opts.Tags...) {
+		digest, err = putRegistryManifest(ctx, registryHost, repository, tag, list.MediaType, body)
+		if err != nil {
+			return "", fmt.Errorf("failed to push manifest list for %s: %w", imageName, err)
+		}
+	}
+	c.logger.Debug("pushed manifest list", "image", c.ImageName(imageName), "digest", digest)
+	return digest, nil
+}
+
+// splitPlatform splits a platform such as "linux/arm64/v8" into its OS,
+// architecture and optional variant.
+func splitPlatform(platform string) (platformOS, platformArch, platformVariant string, err error) {
+	parts := strings.Split(platform, "/")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+		return "", "", "", fmt.Errorf("invalid platform %q, expected os/arch[/variant]", platform)
+	}
+	if len(parts) == 3 {
+		platformVariant = parts[2]
+	}
+	return parts[0], parts[1], platformVariant, nil
+}
@@ -0,0 +1,78 @@
+package kubicle
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// EnsureNamespace creates the named namespace if it does not exist yet.
+func (c *Cluster) EnsureNamespace(ctx context.Context, name string) error {
+	_, err := c.CoreV1().Namespaces().Create(ctx, &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: name,
+		},
+	}, metav1.CreateOptions{})
+	if err != nil && !apierrors.IsAlreadyExists(err) {
+		return fmt.Errorf("failed to create namespace %s: %w", name, err)
+	}
+	return nil
+}
+
+// CreateTempNamespace creates a namespace with a random name, for tests that
+// need isolation from each other. It returns the namespace's name and a
+// function that deletes it.
+func (c *Cluster) CreateTempNamespace(ctx context.Context) (name string, cleanup func(context.Context) error, err error) {
+	ns, err := c.CoreV1().Namespaces().Create(ctx, &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: "kubicle-",
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create namespace: %w", err)
+	}
+
+	cleanup = func(ctx context.Context) error {
+		err := c.CoreV1().Namespaces().Delete(ctx, ns.Name, metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete namespace %s: %w", ns.Name, err)
+		}
+		return nil
+	}
+	return ns.Name, cleanup, nil
+}
+
+// DeleteNamespaceAndWait deletes the named namespace and blocks until it and
+// everything in it is gone, so a namespace of the same name can be created
+// right after. Deleting a namespace that does not exist is not an error. If
+// timeout is zero, it defaults to 1 minute.
+func (c *Cluster) DeleteNamespaceAndWait(ctx context.Context, name string, timeout time.Duration) error {
+	if timeout == 0 {
+		timeout = 1 * time.Minute
+	}
+
+	err := c.CoreV1().Namespaces().Delete(ctx, name, metav1.DeleteOptions{})
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to delete namespace %s: %w", name, err)
+	}
+
+	err = wait.PollUntilContextTimeout(ctx, waitPollInterval, timeout, true, func(ctx context.Context) (bool, error) {
+		_, err := c.CoreV1().Namespaces().Get(ctx, name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return true, nil
+		}
+		return false, err
+	})
+	if err != nil {
+		return fmt.Errorf("failed waiting for namespace %s to be deleted: %w", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,256 @@
+package kubicle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/kind/pkg/cluster"
+	"sigs.k8s.io/kind/pkg/cluster/nodes"
+	"sigs.k8s.io/kind/pkg/cluster/nodeutils"
+)
+
+// containerdCertsDir is the containerd config_path the kind config points
+// nodes at for per-registry host configuration.
+const containerdCertsDir = "/etc/containerd/certs.d"
+
+// configureNodeRegistryHosts writes a containerd hosts.toml on every node of
+// the cluster for each registry host in hosts, mapping it to the given
+// hosts.toml content. containerd reads these files on each pull, so no
+// restart is needed.
+func configureNodeRegistryHosts(provider *cluster.Provider, clusterName string, hosts map[string]string) error {
+	nodes, err := provider.ListInternalNodes(clusterName)
+	if err != nil {
+		return fmt.Errorf("failed to list nodes: %w", err)
+	}
+
+	for _, node := range nodes {
+		for host, content := range hosts {
+			if err := writeNodeFile(node, path.Join(containerdCertsDir, host, "hosts.toml"), content); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// writeNodeRegistryFile writes a file, such as a CA certificate referenced by
+// hosts.toml, into the containerd host configuration directory of host on
+// every node of the cluster.
+func writeNodeRegistryFile(provider *cluster.Provider, clusterName, host, name, content string) error {
+	nodes, err := provider.ListInternalNodes(clusterName)
+	if err != nil {
+		return fmt.Errorf("failed to list nodes: %w", err)
+	}
+
+	for _, node := range nodes {
+		if err := writeNodeFile(node, path.Join(containerdCertsDir, host, name), content); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeNodeFile writes content to filePath on node, creating its directory.
+func writeNodeFile(node nodes.Node, filePath, content string) error {
+	dir := path.Dir(filePath)
+	if err := node.Command("mkdir", "-p", dir).Run(); err != nil {
+		return fmt.Errorf("failed to create %s on node %s: %w", dir, node.String(), err)
+	}
+	if err := nodeutils.WriteFile(node, filePath, content); err != nil {
+		return fmt.Errorf("failed to write %s on node %s: %w", filePath, node.String(), err)
+	}
+	return nil
+}
+
+// registryMirrorHosts returns the hosts.toml content for each upstream
+// registry host in mirrors, pointing it at its mirror endpoint. containerd
+// falls back to the upstream host if the mirror fails.
+func registryMirrorHosts(mirrors map[string]string) map[string]string {
+	hosts := make(map[string]string, len(mirrors))
+	for host, endpoint := range mirrors {
+		hosts[host] = fmt.Sprintf("[host.%q]\n  capabilities = [\"pull\", \"resolve\"]\n", endpoint)
+	}
+	return hosts
+}
+
+// LoadImage copies an image from the local Docker daemon straight into the
+// containerd image store of every node, like kind load docker-image. Unlike
+// BuildAndPushImage no registry is involved, so pods reference the image by
+// its plain name. Pods using a :latest tag must set an image pull policy of
+// IfNotPresent or Never, otherwise the kubelet tries to pull it instead.
+func (c *Cluster) LoadImage(ctx context.Context, imageName string) error {
+	if err := c.checkKind(); err != nil {
+		return err
+	}
+	nodes, err := c.provider.ListInternalNodes(c.Name)
+	if err != nil {
+		return fmt.Errorf("failed to list nodes: %w", err)
+	}
+
+	// The archive is read once per node, so stage it on disk rather than
+	// asking the daemon to export it repeatedly.
+	archive, err := os.CreateTemp("", "kubicle-image-*.tar")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %w", err)
+	}
+	defer os.Remove(archive.Name())
+	defer archive.Close()
+
+	err = saveImage(ctx, archive, imageName)
+	if err != nil {
+		return err
+	}
+
+	for _, node := range nodes {
+		if _, err := archive.Seek(0, io.SeekStart); err != nil {
+			return fmt.Errorf("failed to rewind image archive: %w", err)
+		}
+		if err := nodeutils.LoadImageArchive(node, archive); err != nil {
+			return fmt.Errorf("failed to load image into node %s: %w", node.String(), err)
+		}
+	}
+	return nil
+}
+
+// PrePullImages pulls images into every node ahead of time, so pods that use
+// them start without waiting on a pull. Images may be external references or
+// names returned by ImageName. Nodes pull in parallel; all errors are
+// returned joined.
+func (c *Cluster) PrePullImages(ctx context.Context, images ...string) error {
+	if err := c.checkKind(); err != nil {
+		return err
+	}
+	nodes, err := c.provider.ListInternalNodes(c.Name)
+	if err != nil {
+		return fmt.Errorf("failed to list nodes: %w", err)
+	}
+
+	var (
+		mu   sync.Mutex
+		errs []error
+		wg   sync.WaitGroup
+	)
+	for _, node := range nodes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for _, image := range images {
+				// crictl pulls through the CRI, so the image lands where the
+				// kubelet looks for it and the node's registry hosts apply.
+				err := node.CommandContext(ctx, "crictl", "pull", image).Run()
+				if err != nil {
+					mu.Lock()
+					errs = append(errs, fmt.Errorf("failed to pull image %s on node %s: %w", image, node.String(), err))
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// RemoveImageFromNodes deletes an image from the containerd image store of
+// every node, so the next pod that references it has to load or pull it
+// afresh. Nodes that do not have the image are skipped.
+func (c *Cluster) RemoveImageFromNodes(ctx context.Context, imageName string) error {
+	if err := c.checkKind(); err != nil {
+		return err
+	}
+	nodes, err := c.provider.ListInternalNodes(c.Name)
+	if err != nil {
+		return fmt.Errorf("failed to list nodes: %w", err)
+	}
+
+	for _, node := range nodes {
+		// crictl normalizes imageName the way the kubelet does, so plain
+		// names as used with LoadImage resolve too.
+		if err := node.CommandContext(ctx, "crictl", "inspecti", "-q", imageName).Run(); err != nil {
+			continue
+		}
+		if err := node.CommandContext(ctx, "crictl", "rmi", imageName).Run(); err != nil {
+			return fmt.Errorf("failed to remove image %s from node %s: %w", imageName, node.String(), err)
+		}
+	}
+	return nil
+}
+
+// Node describes a node of the cluster.
+type Node struct {
+	// Name is the name of the node's Docker container, which is also its
+	// Kubernetes node name, e.g. "test-cluster-control-plane".
+	Name string
+	// Role is the node's kind role, "control-plane" or "worker".
+	Role string
+}
+
+// Nodes returns the nodes of the cluster. Their names can be used with
+// ExecInContainer or docker exec to debug a node.
+func (c *Cluster) Nodes(ctx context.Context) ([]Node, error) {
+	if err := c.checkKind(); err != nil {
+		return nil, err
+	}
+	nodes, err := c.provider.ListInternalNodes(c.Name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list nodes: %w", err)
+	}
+
+	result := make([]Node, 0, len(nodes))
+	for _, node := range nodes {
+		role, err := node.Role()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get role of node %s: %w", node.String(), err)
+		}
+		result = append(result, Node{
+			Name: node.String(),
+			Role: role,
+		})
+	}
+	return result, nil
+}
+
+// NodesReady reports whether every node of the cluster has the Ready
+// condition. It returns false if the cluster has no nodes.
+func (c *Cluster) NodesReady(ctx context.Context) (bool, error) {
+	nodes, err := c.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return false, fmt.Errorf("failed to list nodes: %w", err)
+	}
+	if len(nodes.Items) == 0 {
+		return false, nil
+	}
+	for _, node := range nodes.Items {
+		if !nodeReady(&node) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func nodeReady(node *corev1.Node) bool {
+	for _, condition := range node.Status.Conditions {
+		if condition.Type == corev1.NodeReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// ControlPlaneContainer returns the name of the Docker container running the
+// cluster's control-plane node.
+func (c *Cluster) ControlPlaneContainer() string {
+	return controlPlaneContainerName(c.Name)
+}
+
+// controlPlaneContainerName returns the name kind gives the control-plane
+// node container of a single control-plane cluster.
+func controlPlaneContainerName(clusterName string) string {
+	return fmt.Sprintf("%s-control-plane", clusterName)
+}
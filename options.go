@@ -0,0 +1,477 @@
+package kubicle
+
+import (
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/netip"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+
+	"sigs.k8s.io/kind/pkg/apis/config/v1alpha4"
+)
+
+const (
+	// defaultReadyTimeout is how long NewCluster waits for a new cluster to
+	// become ready unless WithReadyTimeout is used.
+	defaultReadyTimeout = 5 * time.Minute
+
+	// defaultRegistryPort is the host port the registry is published on
+	// unless WithRegistryPort is used.
+	defaultRegistryPort = 5000
+	// registryContainerPort is the port the registry listens on inside its
+	// container, and so the port used to reach it from the cluster network.
+	registryContainerPort = 5000
+	// defaultRegistryImage is the image the registry runs unless
+	// WithRegistryImage is used.
+	defaultRegistryImage = "registry:2"
+)
+
+// defaultPodSubnet and defaultServiceSubnet are the IPv4 subnets kind uses
+// unless WithPodSubnet or WithServiceSubnet are used.
+var (
+	defaultPodSubnet     = netip.MustParsePrefix("10.244.0.0/16")
+	defaultServiceSubnet = netip.MustParsePrefix("10.96.0.0/16")
+)
+
+// clusterConfig holds the settings NewCluster builds a cluster from.
+type clusterConfig struct {
+	reuse        bool
+	readyTimeout time.Duration
+	registryPort int
+	workerNodes  int
+	nodeImage    string
+	extraMounts  []configTemplateMount
+
+	extraPortMappings []PortMap
+
+	ipFamily          string
+	disableDefaultCNI bool
+	podSubnet         netip.Prefix
+	serviceSubnet     netip.Prefix
+
+	featureGates  map[string]bool
+	runtimeConfig map[string]string
+
+	dockerNetwork  string
+	configTemplate string
+	kindConfig     *v1alpha4.Cluster
+
+	withoutRegistry      bool
+	registryImage        string
+	registryTLS          bool
+	persistentRegistry   bool
+	removeRegistryVolume bool
+
+	registryMirrors map[string]string
+
+	retry  retryPolicy
+	logger *slog.Logger
+}
+
+func defaultClusterConfig() clusterConfig {
+	return clusterConfig{
+		reuse:         true,
+		readyTimeout:  defaultReadyTimeout,
+		registryPort:  defaultRegistryPort,
+		registryImage: defaultRegistryImage,
+	}
+}
+
+// ClusterOption configures a cluster created by NewCluster.
+type ClusterOption func(*clusterConfig) error
+
+// WithReuse sets whether NewCluster reconnects to an existing cluster of the
+// same name. With WithReuse(false), NewCluster returns ErrClusterExists
+// instead, so a test is guaranteed a freshly created cluster. Defaults to
+// true.
+func WithReuse(reuse bool) ClusterOption {
+	return func(c *clusterConfig) error {
+		c.reuse = reuse
+		return nil
+	}
+}
+
+// WithReadyTimeout sets how long NewCluster waits for a newly created cluster
+// to become ready. It also bounds pulling the registry image. Defaults to 5
+// minutes.
+func WithReadyTimeout(timeout time.Duration) ClusterOption {
+	return func(c *clusterConfig) error {
+		if timeout <= 0 {
+			return fmt.Errorf("invalid ready timeout %s", timeout)
+		}
+		c.readyTimeout = timeout
+		return nil
+	}
+}
+
+// WithRegistryPort sets the host port the cluster's registry is published on.
+// The registry is still reachable from inside the cluster on port 5000.
+// Clusters that run side by side must use different registry ports.
+// When an existing cluster is reused, the port its registry was created with
+// takes precedence. Defaults to 5000.
+func WithRegistryPort(port int) ClusterOption {
+	return func(c *clusterConfig) error {
+		if port < 1 || port > 65535 {
+			return fmt.Errorf("invalid registry port %d", port)
+		}
+		c.registryPort = port
+		return nil
+	}
+}
+
+// WithWorkerNodes adds n worker nodes to the cluster alongside its single
+// control-plane node. Defaults to 0, so workloads run on the control plane.
+func WithWorkerNodes(n int) ClusterOption {
+	return func(c *clusterConfig) error {
+		if n < 0 {
+			return fmt.Errorf("invalid worker node count %d", n)
+		}
+		c.workerNodes = n
+		return nil
+	}
+}
+
+// WithNodeImage sets the kind node image every node runs, which pins the
+// cluster's Kubernetes version, e.g. "kindest/node:v1.29.2". Defaults to the
+// image the kind release in use was built for.
+func WithNodeImage(image string) ClusterOption {
+	return func(c *clusterConfig) error {
+		if image == "" {
+			return fmt.Errorf("node image must not be empty")
+		}
+		c.nodeImage = image
+		return nil
+	}
+}
+
+// WithExtraMount mounts hostPath on the host at containerPath in the
+// control-plane node, so pods scheduled there can reach it through a hostPath
+// volume. hostPath must exist. It may be given more than once.
+func WithExtraMount(hostPath, containerPath string) ClusterOption {
+	return func(c *clusterConfig) error {
+		absHostPath, err := filepath.Abs(hostPath)
+		if err != nil {
+			return fmt.Errorf("invalid extra mount host path %q: %w", hostPath, err)
+		}
+		if _, err := os.Stat(absHostPath); err != nil {
+			return fmt.Errorf("invalid extra mount host path: %w", err)
+		}
+		if !path.IsAbs(containerPath) {
+			return fmt.Errorf("extra mount container path %q must be absolute", containerPath)
+		}
+
+		c.extraMounts = append(c.extraMounts, configTemplateMount{
+			HostPath:      absHostPath,
+			ContainerPath: containerPath,
+		})
+		return nil
+	}
+}
+
+// WithExtraPortMappings publishes ports of the control-plane node on the host,
+// e.g. a NodePort service's node port or an ingress controller's host ports.
+// An empty protocol means TCP. It may be given more than once.
+func WithExtraPortMappings(mappings ...PortMap) ClusterOption {
+	return func(c *clusterConfig) error {
+		for _, pm := range mappings {
+			if pm.Container < 1 || pm.Container > 65535 || pm.Host < 0 || pm.Host > 65535 {
+				return fmt.Errorf("invalid port mapping %d:%d", pm.Host, pm.Container)
+			}
+			switch strings.ToLower(pm.Protocol) {
+			case "", "tcp", "udp", "sctp":
+			default:
+				return fmt.Errorf("invalid port mapping protocol %q", pm.Protocol)
+			}
+		}
+		c.extraPortMappings = append(c.extraPortMappings, mappings...)
+		return nil
+	}
+}
+
+// WithIPFamily sets the IP family of the cluster's pod and service networks:
+// "ipv4", "ipv6" or "dual" for dual-stack, where pods and services get an
+// address of each family. The registry stays reachable in every family.
+// Defaults to "ipv4".
+func WithIPFamily(family string) ClusterOption {
+	return func(c *clusterConfig) error {
+		switch v1alpha4.ClusterIPFamily(family) {
+		case v1alpha4.IPv4Family, v1alpha4.IPv6Family, v1alpha4.DualStackFamily:
+		default:
+			return fmt.Errorf("invalid IP family %q", family)
+		}
+		c.ipFamily = family
+		return nil
+	}
+}
+
+// WithDefaultCNIDisabled creates the cluster without kind's default CNI, e.g.
+// to test installing Calico or Cilium. The nodes stay NotReady and pods other
+// than host network ones stay Pending until a CNI is installed, so NewCluster
+// does not wait for the nodes to become ready; use WaitFor after installing
+// one.
+func WithDefaultCNIDisabled() ClusterOption {
+	return func(c *clusterConfig) error {
+		c.disableDefaultCNI = true
+		return nil
+	}
+}
+
+// WithPodSubnet sets the CIDR pod IPs are allocated from, e.g. to avoid
+// overlapping with a network the host routes to. It must not overlap the
+// service subnet. Defaults to kind's "10.244.0.0/16".
+func WithPodSubnet(cidr string) ClusterOption {
+	return func(c *clusterConfig) error {
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return fmt.Errorf("invalid pod subnet: %w", err)
+		}
+		c.podSubnet = prefix.Masked()
+		return nil
+	}
+}
+
+// WithServiceSubnet sets the CIDR service cluster IPs are allocated from. It
+// must not overlap the pod subnet. Defaults to kind's "10.96.0.0/16".
+func WithServiceSubnet(cidr string) ClusterOption {
+	return func(c *clusterConfig) error {
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return fmt.Errorf("invalid service subnet: %w", err)
+		}
+		c.serviceSubnet = prefix.Masked()
+		return nil
+	}
+}
+
+// WithFeatureGates enables or disables Kubernetes feature gates, keyed by
+// name, e.g. {"InPlacePodVerticalScaling": true}, on every component of the
+// cluster. It may be given more than once.
+func WithFeatureGates(gates map[string]bool) ClusterOption {
+	return func(c *clusterConfig) error {
+		for name := range gates {
+			if name == "" {
+				return fmt.Errorf("feature gate name must not be empty")
+			}
+		}
+		if c.featureGates == nil {
+			c.featureGates = map[string]bool{}
+		}
+		maps.Copy(c.featureGates, gates)
+		return nil
+	}
+}
+
+// WithRuntimeConfig sets the API server's --runtime-config values, e.g.
+// {"api/alpha": "true"} to serve all alpha APIs. It may be given more than
+// once.
+func WithRuntimeConfig(config map[string]string) ClusterOption {
+	return func(c *clusterConfig) error {
+		for key := range config {
+			if key == "" {
+				return fmt.Errorf("runtime config key must not be empty")
+			}
+		}
+		if c.runtimeConfig == nil {
+			c.runtimeConfig = map[string]string{}
+		}
+		maps.Copy(c.runtimeConfig, config)
+		return nil
+	}
+}
+
+// WithoutRegistry creates the cluster without a registry, for workflows that
+// only use LoadImage or external images. Methods that push to or query the
+// registry then return ErrRegistryDisabled.
+func WithoutRegistry() ClusterOption {
+	return func(c *clusterConfig) error {
+		c.withoutRegistry = true
+		return nil
+	}
+}
+
+// WithRegistryImage sets the image the cluster's registry runs, e.g. a mirror
+// of registry:2 in an air-gapped environment. The image must serve the
+// registry v2 API on port 5000. An existing registry container is reused as
+// is. Defaults to "registry:2".
+func WithRegistryImage(ref string) ClusterOption {
+	return func(c *clusterConfig) error {
+		if ref == "" {
+			return fmt.Errorf("registry image must not be empty")
+		}
+		c.registryImage = ref
+		return nil
+	}
+}
+
+// WithRegistryMirrors makes the nodes pull images of each upstream registry
+// host through the given mirror endpoint, e.g. {"docker.io":
+// "http://registry-mirror:5000"} to go through a caching proxy and avoid
+// Docker Hub rate limits. Endpoints must include an http or https scheme and
+// be reachable from the nodes. The upstream registry is used if a mirror
+// fails. This is independent of the cluster's own registry. It may be given
+// more than once.
+func WithRegistryMirrors(mirrors map[string]string) ClusterOption {
+	return func(c *clusterConfig) error {
+		for host, endpoint := range mirrors {
+			if host == "" {
+				return fmt.Errorf("registry mirror host must not be empty")
+			}
+			u, err := url.Parse(endpoint)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return fmt.Errorf("invalid registry mirror endpoint %q for %s", endpoint, host)
+			}
+		}
+		if c.registryMirrors == nil {
+			c.registryMirrors = map[string]string{}
+		}
+		maps.Copy(c.registryMirrors, mirrors)
+		return nil
+	}
+}
+
+// WithRegistryTLS serves the cluster's registry over HTTPS with a certificate
+// signed by a CA kubicle generates, for workloads that refuse to pull from an
+// insecure registry. The nodes are configured to trust the CA, which
+// Cluster.RegistryCACert returns for other clients. The Docker daemon pushes
+// to the registry through localhost, which it does not verify certificates
+// for. An existing registry is reused with the certificates it was created
+// with; it must have been created with TLS as well.
+func WithRegistryTLS() ClusterOption {
+	return func(c *clusterConfig) error {
+		c.registryTLS = true
+		return nil
+	}
+}
+
+// WithDockerNetwork runs the nodes and the registry in the named Docker
+// network instead of kind's default "kind" network, e.g. to reach other
+// containers on a pre-existing network. kind creates the network if it does
+// not exist. kind only reads the network from the process environment, so
+// kubicle sets KIND_EXPERIMENTAL_DOCKER_NETWORK while the cluster is created
+// and creates clusters one at a time; clusters created concurrently by other
+// code may pick it up as well.
+func WithDockerNetwork(name string) ClusterOption {
+	return func(c *clusterConfig) error {
+		if name == "" {
+			return fmt.Errorf("docker network must not be empty")
+		}
+		c.dockerNetwork = name
+		return nil
+	}
+}
+
+// validate checks settings that depend on more than one option.
+func (c clusterConfig) validate() error {
+	podSubnet, serviceSubnet := c.podSubnet, c.serviceSubnet
+	// Compare against kind's defaults if only one subnet is set.
+	if !podSubnet.IsValid() {
+		podSubnet = defaultPodSubnet
+	}
+	if !serviceSubnet.IsValid() {
+		serviceSubnet = defaultServiceSubnet
+	}
+	if (c.podSubnet.IsValid() || c.serviceSubnet.IsValid()) && podSubnet.Overlaps(serviceSubnet) {
+		return fmt.Errorf("pod subnet %s overlaps service subnet %s", podSubnet, serviceSubnet)
+	}
+	return nil
+}
+
+// network returns the Docker network the cluster's nodes run in.
+func (c clusterConfig) network() string {
+	if c.dockerNetwork == "" {
+		return kindNetworkName
+	}
+	return c.dockerNetwork
+}
+
+// WithConfigTemplate replaces the embedded kind config template with tmpl, a
+// text/template for a kind.x-k8s.io/v1alpha4 Cluster, e.g. to set feature
+// gates or kubeadm patches. It is rendered with the same data as the embedded
+// template: .Nodes lists the nodes requested through the other options, each
+// with a .Role, .Image, .ExtraMounts and .ExtraPortMappings, and
+// .FeatureGates and .RuntimeConfig hold the values passed to WithFeatureGates
+// and WithRuntimeConfig. kubicle adds the containerd patch its registry needs
+// to the rendered config.
+func WithConfigTemplate(tmpl string) ClusterOption {
+	return func(c *clusterConfig) error {
+		if _, err := template.New("config").Parse(tmpl); err != nil {
+			return fmt.Errorf("invalid config template: %w", err)
+		}
+		c.configTemplate = tmpl
+		return nil
+	}
+}
+
+// WithKindConfig creates the cluster from cfg instead of the config template,
+// so the options rendered into the template have no effect: WithWorkerNodes,
+// WithNodeImage, WithExtraMount, WithExtraPortMappings, WithIPFamily,
+// WithDefaultCNIDisabled, WithPodSubnet, WithServiceSubnet, WithFeatureGates
+// and WithRuntimeConfig. kubicle adds the containerd patch its registry needs to
+// cfg's containerd patches; cfg itself is not modified.
+func WithKindConfig(cfg v1alpha4.Cluster) ClusterOption {
+	return func(c *clusterConfig) error {
+		c.kindConfig = cfg.DeepCopy()
+		return nil
+	}
+}
+
+// WithPersistentRegistry stores the registry's images in a named Docker volume,
+// <name>-registry-data, instead of in the registry container. Images pushed to
+// the registry then survive the container being removed, so recreating a
+// cluster of the same name keeps its image cache. If removeOnDelete is true,
+// Cluster.Delete removes the volume as well.
+func WithPersistentRegistry(removeOnDelete bool) ClusterOption {
+	return func(c *clusterConfig) error {
+		c.persistentRegistry = true
+		c.removeRegistryVolume = removeOnDelete
+		return nil
+	}
+}
+
+// WithRetry retries pulls, builds and pushes that fail for transient reasons,
+// such as network errors or 5xx responses from a registry, up to attempts
+// times in total. The delay between attempts starts at baseDelay and doubles
+// after each attempt. Authentication failures and other client errors are not
+// retried. Defaults to a single attempt.
+func WithRetry(attempts int, baseDelay time.Duration) ClusterOption {
+	return func(c *clusterConfig) error {
+		if attempts < 1 {
+			return fmt.Errorf("invalid retry attempts %d", attempts)
+		}
+		if baseDelay < 0 {
+			return fmt.Errorf("invalid retry delay %s", baseDelay)
+		}
+		c.retry = retryPolicy{
+			attempts:  attempts,
+			baseDelay: baseDelay,
+		}
+		return nil
+	}
+}
+
+// WithLogger sends kind's cluster creation progress, along with kubicle's own
+// debug messages about the registry and image builds, to logger. Without it,
+// kind prints its usage hints and salutation and kubicle logs nothing.
+func WithLogger(logger *slog.Logger) ClusterOption {
+	return func(c *clusterConfig) error {
+		if logger == nil {
+			return fmt.Errorf("logger must not be nil")
+		}
+		c.logger = logger
+		return nil
+	}
+}
+
+// log returns the configured logger, or one that discards everything.
+func (c clusterConfig) log() *slog.Logger {
+	if c.logger == nil {
+		return discardLogger
+	}
+	return c.logger
+}
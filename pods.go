@@ -0,0 +1,97 @@
+package kubicle
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+// PodLogs streams the logs of a container in a pod. If follow is true, the
+// stream stays open until the container exits or ctx is cancelled. The
+// container name may be empty for pods with a single container. The caller
+// must close the returned reader.
+func (c *Cluster) PodLogs(ctx context.Context, namespace, podName, containerName string, follow bool) (io.ReadCloser, error) {
+	containerName, err := c.podContainerName(ctx, namespace, podName, containerName)
+	if err != nil {
+		return nil, err
+	}
+
+	stream, err := c.CoreV1().Pods(namespace).GetLogs(podName, &corev1.PodLogOptions{
+		Container: containerName,
+		Follow:    follow,
+	}).Stream(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stream logs of pod %s/%s: %w", namespace, podName, err)
+	}
+	return stream, nil
+}
+
+// Exec runs cmd in a container of a running pod and returns what it wrote to
+// stdout and stderr. The container name may be empty for pods with a single
+// container. A command that exits with a non-zero status returns an error
+// along with its output. Cancelling ctx aborts the command.
+func (c *Cluster) Exec(ctx context.Context, namespace, pod, container string, cmd []string) (stdout, stderr string, err error) {
+	var stdoutBuf, stderrBuf bytes.Buffer
+	err = c.exec(ctx, namespace, pod, container, cmd, nil, &stdoutBuf, &stderrBuf)
+	return stdoutBuf.String(), stderrBuf.String(), err
+}
+
+// exec runs cmd in a container of a pod, wiring up the given streams. stdin
+// may be nil.
+func (c *Cluster) exec(ctx context.Context, namespace, pod, container string, cmd []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	container, err := c.podContainerName(ctx, namespace, pod, container)
+	if err != nil {
+		return err
+	}
+
+	req := c.CoreV1().RESTClient().Post().
+		Resource("pods").
+		Namespace(namespace).
+		Name(pod).
+		SubResource("exec").
+		VersionedParams(&corev1.PodExecOptions{
+			Container: container,
+			Command:   cmd,
+			Stdin:     stdin != nil,
+			Stdout:    stdout != nil,
+			Stderr:    stderr != nil,
+		}, scheme.ParameterCodec)
+
+	executor, err := remotecommand.NewSPDYExecutor(c.RESTConfig, "POST", req.URL())
+	if err != nil {
+		return fmt.Errorf("failed to create executor: %w", err)
+	}
+
+	err = executor.StreamWithContext(ctx, remotecommand.StreamOptions{
+		Stdin:  stdin,
+		Stdout: stdout,
+		Stderr: stderr,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to exec in pod %s/%s: %w", namespace, pod, err)
+	}
+	return nil
+}
+
+// podContainerName returns containerName, or the name of the pod's only
+// container if containerName is empty.
+func (c *Cluster) podContainerName(ctx context.Context, namespace, podName, containerName string) (string, error) {
+	if containerName != "" {
+		return containerName, nil
+	}
+
+	pod, err := c.CoreV1().Pods(namespace).Get(ctx, podName, metav1.GetOptions{})
+	if err != nil {
+		return "", fmt.Errorf("failed to get pod %s/%s: %w", namespace, podName, err)
+	}
+	if len(pod.Spec.Containers) != 1 {
+		return "", fmt.Errorf("pod %s/%s has %d containers, a container name is required", namespace, podName, len(pod.Spec.Containers))
+	}
+	return pod.Spec.Containers[0].Name, nil
+}
@@ -0,0 +1,74 @@
+package kubicle
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+
+	"k8s.io/client-go/tools/portforward"
+	"k8s.io/client-go/transport/spdy"
+)
+
+// PortForward forwards localPort on localhost to remotePort of a pod, like
+// kubectl port-forward. If localPort is 0, a free port is picked. It returns
+// the local port in use and a function that stops forwarding; forwarding also
+// stops when ctx is cancelled.
+func (c *Cluster) PortForward(ctx context.Context, namespace, podName string, localPort, remotePort int) (port int, stop func(), err error) {
+	transport, upgrader, err := spdy.RoundTripperFor(c.RESTConfig)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to create round tripper: %w", err)
+	}
+
+	req := c.CoreV1().RESTClient().Post().
+		Resource("pods").
+		Namespace(namespace).
+		Name(podName).
+		SubResource("portforward")
+	dialer := spdy.NewDialer(upgrader, &http.Client{Transport: transport}, http.MethodPost, req.URL())
+
+	stopChan := make(chan struct{})
+	readyChan := make(chan struct{})
+	forwarder, err := portforward.NewOnAddresses(dialer,
+		[]string{"localhost"},
+		[]string{fmt.Sprintf("%d:%d", localPort, remotePort)},
+		stopChan, readyChan, io.Discard, io.Discard,
+	)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to create port forwarder: %w", err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- forwarder.ForwardPorts()
+	}()
+
+	stop = sync.OnceFunc(func() {
+		close(stopChan)
+	})
+
+	select {
+	case <-readyChan:
+	case err := <-done:
+		return 0, nil, fmt.Errorf("failed to forward port to pod %s/%s: %w", namespace, podName, err)
+	case <-ctx.Done():
+		stop()
+		return 0, nil, ctx.Err()
+	}
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			stop()
+		case <-stopChan:
+		}
+	}()
+
+	ports, err := forwarder.GetPorts()
+	if err != nil {
+		stop()
+		return 0, nil, fmt.Errorf("failed to get forwarded ports: %w", err)
+	}
+	return int(ports[0].Local), stop, nil
+}
@@ -0,0 +1,79 @@
+package kubicle
+
+import (
+	"context"
+	"strings"
+
+	"github.com/docker/docker/pkg/jsonmessage"
+)
+
+// ProgressPhase is the step of a build and push a ProgressEvent belongs to.
+type ProgressPhase string
+
+const (
+	ProgressPhaseBuild ProgressPhase = "build"
+	ProgressPhasePush  ProgressPhase = "push"
+)
+
+// ProgressEvent reports progress of BuildAndPushImageWithProgress, decoded
+// from the messages the Docker daemon streams.
+type ProgressEvent struct {
+	Phase ProgressPhase
+	// Message is a line of build output, or the status of a layer being
+	// pulled or pushed prefixed with its ID, e.g. "5f70bf18a086: Pushing".
+	Message string
+	// Percent is the completion of the layer the message is about, from 0 to
+	// 100, or -1 if the message carries no progress.
+	Percent float64
+}
+
+// BuildAndPushImageWithProgress is like BuildAndPushImage but sends progress
+// events for the build, including base image pulls, and for the push to
+// events, e.g. to drive a progress display. events is closed when
+// BuildAndPushImageWithProgress returns. Sending blocks, so the caller must
+// keep receiving until the channel is closed or cancel ctx.
+func (c *Cluster) BuildAndPushImageWithProgress(ctx context.Context, imageName, localPath string, events chan<- ProgressEvent) error {
+	defer close(events)
+
+	opts := BuildOptions{
+		progress: func(phase ProgressPhase, msg jsonmessage.JSONMessage) error {
+			event, ok := newProgressEvent(phase, msg)
+			if !ok {
+				return nil
+			}
+			select {
+			case events <- event:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		},
+	}
+	_, err := c.BuildAndPushImageWithResult(ctx, imageName, localPath, opts)
+	return err
+}
+
+// newProgressEvent converts a Docker JSON message into a ProgressEvent. It
+// reports false for messages with nothing to show, such as aux messages.
+func newProgressEvent(phase ProgressPhase, msg jsonmessage.JSONMessage) (ProgressEvent, bool) {
+	message := strings.TrimSpace(msg.Stream)
+	if message == "" {
+		message = msg.Status
+		if msg.ID != "" && message != "" {
+			message = msg.ID + ": " + message
+		}
+	}
+	if message == "" {
+		return ProgressEvent{}, false
+	}
+
+	percent := -1.0
+	if p := msg.Progress; p != nil && p.Total > 0 {
+		percent = float64(p.Current) * 100 / float64(p.Total)
+	}
+	return ProgressEvent{
+		Phase:   phase,
+		Message: message,
+		Percent: percent,
+	}, true
+}
@@ -0,0 +1,83 @@
+package kubicle
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/docker/docker/pkg/jsonmessage"
+)
+
+func TestNewProgressEvent(t *testing.T) {
+	aux := json.RawMessage(`{"ID":"sha256:0000"}`)
+
+	tests := []struct {
+		name   string
+		phase  ProgressPhase
+		msg    jsonmessage.JSONMessage
+		want   ProgressEvent
+		wantOK bool
+	}{
+		{
+			name:   "build output",
+			phase:  ProgressPhaseBuild,
+			msg:    jsonmessage.JSONMessage{Stream: "Step 1/2 : FROM busybox\n"},
+			want:   ProgressEvent{Phase: ProgressPhaseBuild, Message: "Step 1/2 : FROM busybox", Percent: -1},
+			wantOK: true,
+		},
+		{
+			name:   "status",
+			phase:  ProgressPhasePush,
+			msg:    jsonmessage.JSONMessage{Status: "The push refers to repository [localhost:5000/app]"},
+			want:   ProgressEvent{Phase: ProgressPhasePush, Message: "The push refers to repository [localhost:5000/app]", Percent: -1},
+			wantOK: true,
+		},
+		{
+			name:  "layer progress",
+			phase: ProgressPhasePush,
+			msg: jsonmessage.JSONMessage{
+				ID:       "5f70bf18a086",
+				Status:   "Pushing",
+				Progress: &jsonmessage.JSONProgress{Current: 25, Total: 200},
+			},
+			want:   ProgressEvent{Phase: ProgressPhasePush, Message: "5f70bf18a086: Pushing", Percent: 12.5},
+			wantOK: true,
+		},
+		{
+			name:  "progress without total",
+			phase: ProgressPhaseBuild,
+			msg: jsonmessage.JSONMessage{
+				ID:       "5f70bf18a086",
+				Status:   "Extracting",
+				Progress: &jsonmessage.JSONProgress{Current: 25},
+			},
+			want:   ProgressEvent{Phase: ProgressPhaseBuild, Message: "5f70bf18a086: Extracting", Percent: -1},
+			wantOK: true,
+		},
+		{
+			name:  "aux",
+			phase: ProgressPhaseBuild,
+			msg:   jsonmessage.JSONMessage{ID: "moby.image.id", Aux: &aux},
+		},
+		{
+			name:  "whitespace stream",
+			phase: ProgressPhaseBuild,
+			msg:   jsonmessage.JSONMessage{Stream: "\n"},
+		},
+		{
+			// Errors end the stream and are returned by the build or
+			// push instead.
+			name:  "error",
+			phase: ProgressPhasePush,
+			msg:   jsonmessage.JSONMessage{Error: &jsonmessage.JSONError{Message: "denied"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := newProgressEvent(tt.phase, tt.msg)
+			if ok != tt.wantOK || got != tt.want {
+				t.Errorf("newProgressEvent() = %+v, %v, want %+v, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
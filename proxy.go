@@ -0,0 +1,78 @@
+package kubicle
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilnet "k8s.io/apimachinery/pkg/util/net"
+	"k8s.io/client-go/rest"
+)
+
+// ServiceProxyClient returns an HTTP client that sends requests to port of
+// the named service through the API server's service proxy, so tests can
+// talk HTTP to a service without a port-forward. Only the path and query of
+// request URLs are used, e.g. client.Get("http://my-service/healthz"). The
+// returned function releases the client's idle connections.
+func (c *Cluster) ServiceProxyClient(ctx context.Context, namespace, service string, port int) (*http.Client, func(), error) {
+	svc, err := c.CoreV1().Services(namespace).Get(ctx, service, metav1.GetOptions{})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get service %s/%s: %w", namespace, service, err)
+	}
+	if !slices.ContainsFunc(svc.Spec.Ports, func(p corev1.ServicePort) bool {
+		return int(p.Port) == port
+	}) {
+		return nil, nil, fmt.Errorf("service %s/%s has no port %d", namespace, service, port)
+	}
+
+	transport, err := rest.TransportFor(c.RESTConfig)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create transport: %w", err)
+	}
+	base := c.CoreV1().RESTClient().Get().
+		Resource("services").
+		Namespace(namespace).
+		Name(service + ":" + strconv.Itoa(port)).
+		SubResource("proxy").
+		URL()
+
+	client := &http.Client{
+		Transport: &serviceProxyTransport{base: base, transport: transport},
+	}
+	return client, client.CloseIdleConnections, nil
+}
+
+// serviceProxyTransport sends requests to the service proxy URL base,
+// appending the request's path and query.
+type serviceProxyTransport struct {
+	base      *url.URL
+	transport http.RoundTripper
+}
+
+func (t *serviceProxyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	u := *t.base
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + strings.TrimPrefix(req.URL.Path, "/")
+	u.RawPath = ""
+	if req.URL.RawPath != "" {
+		u.RawPath = strings.TrimSuffix(t.base.EscapedPath(), "/") + "/" + strings.TrimPrefix(req.URL.RawPath, "/")
+	}
+	u.RawQuery = req.URL.RawQuery
+
+	// A RoundTripper must not modify the caller's request.
+	proxied := req.Clone(req.Context())
+	proxied.URL = &u
+	proxied.Host = ""
+	return t.transport.RoundTrip(proxied)
+}
+
+// CloseIdleConnections lets http.Client.CloseIdleConnections reach the
+// underlying transport.
+func (t *serviceProxyTransport) CloseIdleConnections() {
+	utilnet.CloseIdleConnectionsFor(t.transport)
+}
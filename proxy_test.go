@@ -0,0 +1,97 @@
+package kubicle
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// newTestAPIServer starts a server that serves the service web in namespace
+// apps with port 8080 and answers every other request with its escaped path
+// and query.
+func newTestAPIServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/v1/namespaces/apps/services/web" {
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(&corev1.Service{
+				TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Service"},
+				ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "apps"},
+				Spec:       corev1.ServiceSpec{Ports: []corev1.ServicePort{{Port: 8080}}},
+			})
+			return
+		}
+		_, _ = io.WriteString(w, r.URL.EscapedPath()+"?"+r.URL.RawQuery)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// attachTestCluster attaches to the API server at server.
+func attachTestCluster(t *testing.T, server string) *Cluster {
+	t.Helper()
+	kubeconfig, err := clientcmd.Write(*testKubeconfig(t, "test", server))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := NewClusterFromKubeconfig(context.Background(), kubeconfig, "")
+	if err != nil {
+		t.Fatalf("NewClusterFromKubeconfig() = %v", err)
+	}
+	return c
+}
+
+func TestServiceProxyClient(t *testing.T) {
+	server := newTestAPIServer(t)
+	c := attachTestCluster(t, server.URL)
+
+	client, closeClient, err := c.ServiceProxyClient(context.Background(), "apps", "web", 8080)
+	if err != nil {
+		t.Fatalf("ServiceProxyClient() = %v", err)
+	}
+	defer closeClient()
+
+	const proxy = "/api/v1/namespaces/apps/services/web:8080/proxy"
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"http://web/healthz", proxy + "/healthz?"},
+		{"http://web/", proxy + "/?"},
+		{"http://ignored.example.com/api/items?page=2&sort=name", proxy + "/api/items?page=2&sort=name"},
+		{"http://web/files/a%2Fb", proxy + "/files/a%2Fb?"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			resp, err := client.Get(tt.url)
+			if err != nil {
+				t.Fatalf("Get() = %v", err)
+			}
+			defer resp.Body.Close()
+			got, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("API server got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestServiceProxyClientUnknownPort(t *testing.T) {
+	server := newTestAPIServer(t)
+	c := attachTestCluster(t, server.URL)
+
+	if _, _, err := c.ServiceProxyClient(context.Background(), "apps", "web", 9090); err == nil {
+		t.Error("ServiceProxyClient() = nil, want an error for a port the service does not have")
+	}
+}
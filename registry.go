@@ -0,0 +1,415 @@
+package kubicle
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/distribution/reference"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// ErrRegistryDeleteDisabled is returned when deleting from a registry that
+// was started without REGISTRY_STORAGE_DELETE_ENABLED=true.
+var ErrRegistryDeleteDisabled = errors.New("registry does not allow deletes, start it with REGISTRY_STORAGE_DELETE_ENABLED=true")
+
+// ErrRegistryDisabled is returned by methods that need the cluster's registry
+// when the cluster was created WithoutRegistry. Use LoadImage to get locally
+// built images into such a cluster.
+var ErrRegistryDisabled = errors.New("cluster registry is disabled, use LoadImage instead")
+
+// ErrRegistryPortInUse is returned by NewCluster when the host port the
+// registry is to be published on is taken. The error names the port; retry
+// with a different one using WithRegistryPort.
+var ErrRegistryPortInUse = errors.New("registry host port is already in use")
+
+// ErrRegistryNameConflict is returned by NewCluster when a container named
+// <cluster>-registry exists that kubicle did not create for the cluster, so it
+// cannot be used as the cluster's registry. Registry containers created by
+// kubicle versions that did not label them have to be removed once as well.
+var ErrRegistryNameConflict = errors.New("registry container name is taken by a container kubicle did not create")
+
+// checkRegistryOwner returns ErrRegistryNameConflict unless the named
+// container carries the label kubicle sets on clusterName's registry.
+func checkRegistryOwner(ctx context.Context, containerName, clusterName string) error {
+	labels, err := GetContainerLabels(ctx, containerName)
+	if err != nil {
+		return fmt.Errorf("failed to get registry container labels: %w", err)
+	}
+	if labels[clusterLabel] != clusterName {
+		return fmt.Errorf("%w: %s", ErrRegistryNameConflict, containerName)
+	}
+	return nil
+}
+
+// manifestMediaTypes are the manifest formats kubicle accepts from the
+// registry. Without an Accept header the registry falls back to a legacy
+// format whose digest does not match the pushed manifest.
+var manifestMediaTypes = []string{
+	"application/vnd.docker.distribution.manifest.v2+json",
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.oci.image.index.v1+json",
+}
+
+// parseRegistryImage splits an image name such as "my-service:latest" into
+// the repository and the tag or digest used to address it in a registry.
+// The tag defaults to "latest".
+func parseRegistryImage(registryHost, imageName string) (repository, ref string, err error) {
+	named, err := reference.ParseNamed(registryHost + "/" + imageName)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to parse image name %q: %w", imageName, err)
+	}
+
+	repository = reference.Path(named)
+	switch r := named.(type) {
+	case reference.Canonical:
+		ref = r.Digest().String()
+	case reference.Tagged:
+		ref = r.Tag()
+	default:
+		ref = "latest"
+	}
+	return repository, ref, nil
+}
+
+// registryImageNames returns the references under which imageName is pushed
+// to the registry at registryHost: imageName itself, followed by one
+// reference per tag in tags, see BuildOptions.Tags.
+func registryImageNames(registryHost, imageName string, tags []string) ([]string, error) {
+	names := []string{fmt.Sprintf("%s/%s", registryHost, imageName)}
+	if len(tags) == 0 {
+		return names, nil
+	}
+
+	named, err := reference.ParseNamed(names[0])
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse image name %q: %w", imageName, err)
+	}
+	if _, ok := named.(reference.Canonical); ok {
+		return nil, fmt.Errorf("image %s must be referenced by tag, not digest, to add tags", imageName)
+	}
+	for _, tag := range tags {
+		tagged, err := reference.WithTag(reference.TrimNamed(named), tag)
+		if err != nil {
+			return nil, fmt.Errorf("invalid tag %q: %w", tag, err)
+		}
+		names = append(names, tagged.String())
+	}
+	return names, nil
+}
+
+// registryRequest sends a request to the registry v2 API at registryHost, over
+// HTTPS if it is a TLS registry. body may be nil.
+func registryRequest(ctx context.Context, method, registryHost, path string, header http.Header, body io.Reader) (*http.Response, error) {
+	scheme, client := registryClient(registryHost)
+	url := fmt.Sprintf("%s://%s/v2/%s", scheme, registryHost, strings.TrimPrefix(path, "/"))
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create registry request: %w", err)
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach registry: %w", err)
+	}
+	return resp, nil
+}
+
+// checkPortFree returns ErrRegistryPortInUse if port cannot be bound on the
+// host.
+func checkPortFree(port int) error {
+	l, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return fmt.Errorf("%w: %d", ErrRegistryPortInUse, port)
+	}
+	return l.Close()
+}
+
+// isPortInUseError reports whether err is Docker failing to publish a port
+// because it is taken, which the daemon only reports as text.
+func isPortInUseError(err error) bool {
+	if err == nil {
+		return false
+	}
+	msg := err.Error()
+	return strings.Contains(msg, "port is already allocated") ||
+		strings.Contains(msg, "address already in use")
+}
+
+// registryReadyTimeout bounds how long NewCluster waits for the registry to
+// start serving requests.
+const registryReadyTimeout = 1 * time.Minute
+
+// waitForRegistry polls the registry at registryHost until its API responds,
+// so the first push after creating a cluster does not race the registry
+// binding its port.
+func waitForRegistry(ctx context.Context, registryHost string) error {
+	err := wait.PollUntilContextTimeout(ctx, waitPollInterval, registryReadyTimeout, true, func(ctx context.Context) (bool, error) {
+		resp, err := registryRequest(ctx, http.MethodGet, registryHost, "", nil, nil)
+		if err != nil {
+			// The registry may not be listening yet.
+			return false, nil
+		}
+		resp.Body.Close()
+		return resp.StatusCode == http.StatusOK, nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed waiting for registry at %s: %w", registryHost, err)
+	}
+	return nil
+}
+
+// manifestDescriptor identifies a manifest stored in a registry.
+type manifestDescriptor struct {
+	MediaType string `json:"mediaType"`
+	Size      int64  `json:"size"`
+	Digest    string `json:"digest"`
+}
+
+// registryManifest resolves a tag or digest in repository to the descriptor
+// of its manifest. It reports false if the registry does not know it.
+func registryManifest(ctx context.Context, registryHost, repository, ref string) (manifestDescriptor, bool, error) {
+	resp, err := registryRequest(ctx, http.MethodHead, registryHost, fmt.Sprintf("%s/manifests/%s", repository, ref), http.Header{
+		"Accept": manifestMediaTypes,
+	}, nil)
+	if err != nil {
+		return manifestDescriptor{}, false, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		desc := manifestDescriptor{
+			MediaType: resp.Header.Get("Content-Type"),
+			Size:      resp.ContentLength,
+			Digest:    resp.Header.Get("Docker-Content-Digest"),
+		}
+		if desc.Digest == "" {
+			return manifestDescriptor{}, false, errors.New("registry did not return a manifest digest")
+		}
+		return desc, true, nil
+	case http.StatusNotFound:
+		return manifestDescriptor{}, false, nil
+	default:
+		return manifestDescriptor{}, false, fmt.Errorf("unexpected registry response: %s", resp.Status)
+	}
+}
+
+// registryManifestDigest resolves a tag or digest in repository to the digest
+// of its manifest. It reports false if the registry does not know it.
+func registryManifestDigest(ctx context.Context, registryHost, repository, ref string) (string, bool, error) {
+	desc, found, err := registryManifest(ctx, registryHost, repository, ref)
+	return desc.Digest, found, err
+}
+
+// putRegistryManifest uploads a manifest of the given media type to
+// repository under ref and returns its digest.
+func putRegistryManifest(ctx context.Context, registryHost, repository, ref, mediaType string, manifest []byte) (string, error) {
+	resp, err := registryRequest(ctx, http.MethodPut, registryHost, fmt.Sprintf("%s/manifests/%s", repository, ref), http.Header{
+		"Content-Type": {mediaType},
+	}, bytes.NewReader(manifest))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return "", fmt.Errorf("unexpected registry response: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return resp.Header.Get("Docker-Content-Digest"), nil
+}
+
+// ImageExistsInRegistry reports whether an image, e.g. "my-service:latest", is
+// present in the cluster's registry. It can be used to skip rebuilding images
+// that were already pushed.
+func (c *Cluster) ImageExistsInRegistry(ctx context.Context, imageName string) (bool, error) {
+	if err := c.checkRegistry(); err != nil {
+		return false, err
+	}
+	registryHost := c.HostRegistryAddress()
+	repository, ref, err := parseRegistryImage(registryHost, imageName)
+	if err != nil {
+		return false, err
+	}
+
+	_, found, err := registryManifestDigest(ctx, registryHost, repository, ref)
+	if err != nil {
+		return false, fmt.Errorf("failed to resolve image %s: %w", imageName, err)
+	}
+	return found, nil
+}
+
+// DeleteImageFromRegistry deletes an image, e.g. "my-service:latest", from the
+// cluster's registry. Only the manifest is deleted; the registry reclaims the
+// space used by its layers when it is garbage collected.
+func (c *Cluster) DeleteImageFromRegistry(ctx context.Context, imageName string) error {
+	if err := c.checkRegistry(); err != nil {
+		return err
+	}
+	registryHost := c.HostRegistryAddress()
+	repository, ref, err := parseRegistryImage(registryHost, imageName)
+	if err != nil {
+		return err
+	}
+
+	digest, found, err := registryManifestDigest(ctx, registryHost, repository, ref)
+	if err != nil {
+		return fmt.Errorf("failed to resolve image %s: %w", imageName, err)
+	}
+	if !found {
+		return fmt.Errorf("image %s not found in registry", imageName)
+	}
+
+	resp, err := registryRequest(ctx, http.MethodDelete, registryHost, fmt.Sprintf("%s/manifests/%s", repository, digest), nil, nil)
+	if err != nil {
+		return fmt.Errorf("failed to delete image %s: %w", imageName, err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusAccepted, http.StatusOK:
+		return nil
+	case http.StatusMethodNotAllowed:
+		return fmt.Errorf("failed to delete image %s: %w", imageName, ErrRegistryDeleteDisabled)
+	default:
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("failed to delete image %s: unexpected registry response: %s: %s", imageName, resp.Status, strings.TrimSpace(string(body)))
+	}
+}
+
+// ListRegistryRepositories returns the names of the repositories in the
+// cluster's registry, e.g. "my-service". Repositories whose images were all
+// deleted are still listed.
+func (c *Cluster) ListRegistryRepositories(ctx context.Context) ([]string, error) {
+	if err := c.checkRegistry(); err != nil {
+		return nil, err
+	}
+
+	var repositories []string
+	err := registryList(ctx, c.HostRegistryAddress(), "_catalog", func(dec *json.Decoder) error {
+		var page struct {
+			Repositories []string `json:"repositories"`
+		}
+		if err := dec.Decode(&page); err != nil {
+			return err
+		}
+		repositories = append(repositories, page.Repositories...)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list registry repositories: %w", err)
+	}
+	return repositories, nil
+}
+
+// ListRegistryTags returns the tags of repository in the cluster's registry.
+// A repository that does not exist has no tags.
+func (c *Cluster) ListRegistryTags(ctx context.Context, repository string) ([]string, error) {
+	if err := c.checkRegistry(); err != nil {
+		return nil, err
+	}
+
+	var tags []string
+	err := registryList(ctx, c.HostRegistryAddress(), fmt.Sprintf("%s/tags/list", repository), func(dec *json.Decoder) error {
+		var page struct {
+			Tags []string `json:"tags"`
+		}
+		if err := dec.Decode(&page); err != nil {
+			return err
+		}
+		tags = append(tags, page.Tags...)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tags of %s: %w", repository, err)
+	}
+	return tags, nil
+}
+
+// registryList fetches every page of a paginated registry listing starting at
+// path, handing each page's body to decode. Pages are chained through the
+// Link header. A listing that does not exist is empty.
+func registryList(ctx context.Context, registryHost, path string, decode func(*json.Decoder) error) error {
+	for path != "" {
+		resp, err := registryRequest(ctx, http.MethodGet, registryHost, path, nil, nil)
+		if err != nil {
+			return err
+		}
+
+		if resp.StatusCode == http.StatusNotFound {
+			resp.Body.Close()
+			return nil
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return fmt.Errorf("unexpected registry response: %s", resp.Status)
+		}
+
+		err = decode(json.NewDecoder(resp.Body))
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("failed to decode registry response: %w", err)
+		}
+		path = nextRegistryPage(resp.Header.Get("Link"))
+	}
+	return nil
+}
+
+// nextRegistryPage extracts the path of the next page, relative to /v2/, from
+// a Link header such as `</v2/_catalog?last=b&n=100>; rel="next"`. The
+// header may hold several links, and their targets may be absolute URLs. It
+// returns "" if there is no next page.
+func nextRegistryPage(link string) string {
+	for _, value := range strings.Split(link, ",") {
+		target, params, ok := strings.Cut(value, ";")
+		if !ok || !strings.Contains(params, `rel="next"`) {
+			continue
+		}
+		target = strings.TrimSpace(target)
+		if !strings.HasPrefix(target, "<") || !strings.HasSuffix(target, ">") {
+			return ""
+		}
+		u, err := url.Parse(target[1 : len(target)-1])
+		if err != nil {
+			return ""
+		}
+		next := strings.TrimPrefix(u.EscapedPath(), "/v2/")
+		if u.RawQuery != "" {
+			next += "?" + u.RawQuery
+		}
+		return next
+	}
+	return ""
+}
+
+// GarbageCollectRegistry runs the registry's garbage collector, freeing the
+// storage used by layers that no image references anymore, e.g. after
+// DeleteImageFromRegistry or after pushing over the same tag repeatedly.
+func (c *Cluster) GarbageCollectRegistry(ctx context.Context) error {
+	if err := c.checkRegistry(); err != nil {
+		return err
+	}
+	if err := c.checkKind(); err != nil {
+		return err
+	}
+	_, err := ExecInContainer(ctx, fmt.Sprintf("%s-registry", c.Name), []string{
+		"registry", "garbage-collect", "--delete-untagged", "/etc/docker/registry/config.yml",
+	})
+	if err != nil {
+		return fmt.Errorf("failed to garbage collect registry: %w", err)
+	}
+	return nil
+}
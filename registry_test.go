@@ -0,0 +1,177 @@
+package kubicle
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"slices"
+	"testing"
+)
+
+func TestNextRegistryPage(t *testing.T) {
+	tests := []struct {
+		name string
+		link string
+		want string
+	}{
+		{"none", "", ""},
+		{"next", `</v2/_catalog?last=b&n=100>; rel="next"`, "_catalog?last=b&n=100"},
+		{"tags", `</v2/my/app/tags/list?last=v1&n=2>; rel="next"`, "my/app/tags/list?last=v1&n=2"},
+		{"absolute URL", `<https://registry.example.com/v2/_catalog?last=b>; rel="next"`, "_catalog?last=b"},
+		{"other relation", `</v2/_catalog?last=a>; rel="prev"`, ""},
+		{"several links", `</v2/_catalog?last=a>; rel="prev", </v2/_catalog?last=c>; rel="next"`, "_catalog?last=c"},
+		{"no params", `</v2/_catalog?last=b>`, ""},
+		{"malformed target", `/v2/_catalog?last=b; rel="next"`, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := nextRegistryPage(tt.link); got != tt.want {
+				t.Errorf("nextRegistryPage(%q) = %q, want %q", tt.link, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRegistryImageNames(t *testing.T) {
+	const digest = "sha256:0000000000000000000000000000000000000000000000000000000000000000"
+
+	tests := []struct {
+		name      string
+		imageName string
+		tags      []string
+		want      []string
+		wantErr   bool
+	}{
+		{
+			name:      "no tags",
+			imageName: "my-service:latest",
+			want:      []string{"localhost:5000/my-service:latest"},
+		},
+		{
+			name:      "no tags keeps digest",
+			imageName: "my-service@" + digest,
+			want:      []string{"localhost:5000/my-service@" + digest},
+		},
+		{
+			name:      "tags replace the image's tag",
+			imageName: "team/my-service:latest",
+			tags:      []string{"v1.2.3", "dev"},
+			want: []string{
+				"localhost:5000/team/my-service:latest",
+				"localhost:5000/team/my-service:v1.2.3",
+				"localhost:5000/team/my-service:dev",
+			},
+		},
+		{
+			name:      "untagged image",
+			imageName: "my-service",
+			tags:      []string{"v1"},
+			want:      []string{"localhost:5000/my-service", "localhost:5000/my-service:v1"},
+		},
+		{
+			name:      "invalid tag",
+			imageName: "my-service",
+			tags:      []string{"not a tag"},
+			wantErr:   true,
+		},
+		{
+			name:      "tag too long",
+			imageName: "my-service",
+			tags:      []string{string(make([]byte, 129))},
+			wantErr:   true,
+		},
+		{
+			name:      "digest with tags",
+			imageName: "my-service@" + digest,
+			tags:      []string{"v1"},
+			wantErr:   true,
+		},
+		{
+			name:      "invalid image name",
+			imageName: "My-Service",
+			tags:      []string{"v1"},
+			wantErr:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := registryImageNames("localhost:5000", tt.imageName, tt.tags)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("registryImageNames() = %v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("registryImageNames() = %v", err)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("registryImageNames() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRegistryClientScheme(t *testing.T) {
+	tests := []struct {
+		host string
+		want string
+	}{
+		{"localhost:5000", "http"},
+		{"localhost", "http"},
+		{"127.0.0.1:5001", "http"},
+		{"[::1]:5000", "http"},
+		{"registry.example.com", "https"},
+		{"registry.example.com:5000", "https"},
+		{"10.0.0.5:5000", "https"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			if got, _ := registryClient(tt.host); got != tt.want {
+				t.Errorf("registryClient(%q) scheme = %q, want %q", tt.host, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRegistryClientSchemeAfterClearingCA(t *testing.T) {
+	const host = "localhost:5999"
+	certs, err := generateRegistryCerts([]string{"localhost"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := setRegistryCA(host, certs.caCert); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { clearRegistryCA(host) })
+
+	if scheme, client := registryClient(host); scheme != "https" || client == http.DefaultClient {
+		t.Fatalf("registryClient(%q) = %q, %v, want https with the registry's CA", host, scheme, client)
+	}
+	// Deleting the registry, or recreating it without TLS, clears the CA.
+	clearRegistryCA(host)
+	if scheme, client := registryClient(host); scheme != "http" || client != http.DefaultClient {
+		t.Errorf("registryClient(%q) = %q, %v, want plain http", host, scheme, client)
+	}
+}
+
+func TestCheckPortFree(t *testing.T) {
+	l, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+
+	if err := checkPortFree(port); !errors.Is(err, ErrRegistryPortInUse) {
+		t.Errorf("checkPortFree(%d) with a listener = %v, want %v", port, err, ErrRegistryPortInUse)
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkPortFree(port); err != nil {
+		t.Errorf("checkPortFree(%d) after closing the listener = %v, want nil", port, err)
+	}
+}
@@ -0,0 +1,91 @@
+package kubicle
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"syscall"
+	"time"
+
+	cerrdefs "github.com/containerd/errdefs"
+	"github.com/docker/docker/pkg/jsonmessage"
+)
+
+// retryPolicy retries Docker and registry operations that fail for transient
+// reasons. The zero value makes a single attempt.
+type retryPolicy struct {
+	attempts  int
+	baseDelay time.Duration
+}
+
+// do calls fn until it succeeds, fails with an error that is not retryable,
+// or has been called p.attempts times. The delay between attempts starts at
+// p.baseDelay and doubles after each one. It gives up early if ctx is done.
+func (p retryPolicy) do(ctx context.Context, fn func() error) error {
+	delay := p.baseDelay
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= p.attempts || !isRetryable(err) {
+			return err
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return errors.Join(err, ctx.Err())
+		case <-timer.C:
+		}
+		delay *= 2
+	}
+}
+
+// transientErrorMessages are fragments of errors that the daemon only reports
+// as text, e.g. registry failures relayed through a pull's message stream.
+var transientErrorMessages = []string{
+	"500 Internal Server Error",
+	"502 Bad Gateway",
+	"503 Service Unavailable",
+	"504 Gateway Timeout",
+	"connection reset by peer",
+	"connection refused",
+	"i/o timeout",
+	"TLS handshake timeout",
+	"unexpected EOF",
+}
+
+// isRetryable reports whether err looks transient, such as a network error or
+// a 5xx response from a registry. Authentication failures, missing images and
+// other 4xx-style errors are not retried, nor is a cancelled context.
+func isRetryable(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if cerrdefs.IsUnauthorized(err) || cerrdefs.IsPermissionDenied(err) ||
+		cerrdefs.IsNotFound(err) || cerrdefs.IsInvalidArgument(err) {
+		return false
+	}
+	if cerrdefs.IsUnavailable(err) || cerrdefs.IsInternal(err) {
+		return true
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) {
+		return true
+	}
+	var jsonErr *jsonmessage.JSONError
+	if errors.As(err, &jsonErr) && jsonErr.Code >= 500 {
+		return true
+	}
+
+	msg := err.Error()
+	for _, fragment := range transientErrorMessages {
+		if strings.Contains(msg, fragment) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,136 @@
+package kubicle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	cerrdefs "github.com/containerd/errdefs"
+	"github.com/docker/docker/pkg/jsonmessage"
+)
+
+// flaky returns a function that fails with err for the first failures calls
+// and succeeds afterwards, and a pointer to the number of calls made.
+func flaky(failures int, err error) (func() error, *int) {
+	calls := 0
+	return func() error {
+		calls++
+		if calls <= failures {
+			return err
+		}
+		return nil
+	}, &calls
+}
+
+func TestRetryPolicyDo(t *testing.T) {
+	transient := fmt.Errorf("failed to push image: %w", syscall.ECONNRESET)
+	permanent := cerrdefs.ErrUnauthenticated
+
+	tests := []struct {
+		name      string
+		policy    retryPolicy
+		failures  int
+		err       error
+		wantCalls int
+		wantErr   error
+	}{
+		{
+			name:      "succeeds on third attempt",
+			policy:    retryPolicy{attempts: 3, baseDelay: time.Millisecond},
+			failures:  2,
+			err:       transient,
+			wantCalls: 3,
+		},
+		{
+			name:      "gives up after attempts",
+			policy:    retryPolicy{attempts: 2, baseDelay: time.Millisecond},
+			failures:  5,
+			err:       transient,
+			wantCalls: 2,
+			wantErr:   transient,
+		},
+		{
+			name:      "does not retry permanent errors",
+			policy:    retryPolicy{attempts: 3, baseDelay: time.Millisecond},
+			failures:  5,
+			err:       permanent,
+			wantCalls: 1,
+			wantErr:   permanent,
+		},
+		{
+			name:      "zero value makes a single attempt",
+			failures:  5,
+			err:       transient,
+			wantCalls: 1,
+			wantErr:   transient,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fn, calls := flaky(tt.failures, tt.err)
+			err := tt.policy.do(context.Background(), fn)
+			if tt.wantErr == nil && err != nil {
+				t.Fatalf("do() = %v, want nil", err)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Fatalf("do() = %v, want %v", err, tt.wantErr)
+			}
+			if *calls != tt.wantCalls {
+				t.Errorf("fn called %d times, want %d", *calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestRetryPolicyDoStopsWhenContextDone(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	fn, calls := flaky(5, syscall.ECONNREFUSED)
+	err := retryPolicy{attempts: 3, baseDelay: time.Hour}.do(ctx, fn)
+	if !errors.Is(err, context.Canceled) || !errors.Is(err, syscall.ECONNREFUSED) {
+		t.Fatalf("do() = %v, want the last error joined with context.Canceled", err)
+	}
+	if *calls != 1 {
+		t.Errorf("fn called %d times, want 1", *calls)
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"connection reset", fmt.Errorf("push: %w", syscall.ECONNRESET), true},
+		{"connection refused", syscall.ECONNREFUSED, true},
+		{"unexpected EOF", io.ErrUnexpectedEOF, true},
+		{"net error", &net.OpError{Op: "dial", Err: errors.New("no route")}, true},
+		{"unavailable", cerrdefs.ErrUnavailable, true},
+		{"internal", cerrdefs.ErrInternal, true},
+		{"5xx json error", &jsonmessage.JSONError{Code: 503, Message: "unavailable"}, true},
+		{"5xx message", errors.New("received unexpected HTTP status: 502 Bad Gateway"), true},
+		{"cancelled", fmt.Errorf("build: %w", context.Canceled), false},
+		{"deadline", context.DeadlineExceeded, false},
+		{"cancelled network error", errors.Join(context.Canceled, syscall.ECONNRESET), false},
+		{"unauthorized", cerrdefs.ErrUnauthenticated, false},
+		{"not found", cerrdefs.ErrNotFound, false},
+		{"invalid argument", cerrdefs.ErrInvalidArgument, false},
+		{"4xx json error", &jsonmessage.JSONError{Code: 404, Message: "not found"}, false},
+		{"other", errors.New("dockerfile parse error"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isRetryable(tt.err); got != tt.want {
+				t.Errorf("isRetryable(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
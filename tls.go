@@ -0,0 +1,148 @@
+package kubicle
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// registryCertsDir is where a TLS registry's certificate, key and CA are
+	// kept inside its container.
+	registryCertsDir = "/certs"
+
+	registryCACertFile = "ca.crt"
+	registryCertFile   = "tls.crt"
+	registryKeyFile    = "tls.key"
+
+	// registryCertValidity is how long the certificates kubicle generates
+	// for a TLS registry are valid.
+	registryCertValidity = 10 * 365 * 24 * time.Hour
+)
+
+// registryCerts holds the PEM encoded certificates and key of a TLS registry.
+type registryCerts struct {
+	caCert []byte
+	cert   []byte
+	key    []byte
+}
+
+// generateRegistryCerts creates a self-signed CA and a server certificate
+// signed by it that is valid for hosts, which may be DNS names or IPs.
+func generateRegistryCerts(hosts []string) (registryCerts, error) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return registryCerts{}, fmt.Errorf("failed to generate CA key: %w", err)
+	}
+	now := time.Now()
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "kubicle registry CA"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(registryCertValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		return registryCerts{}, fmt.Errorf("failed to create CA certificate: %w", err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return registryCerts{}, fmt.Errorf("failed to generate registry key: %w", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: hosts[0]},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(registryCertValidity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, caTemplate, &key.PublicKey, caKey)
+	if err != nil {
+		return registryCerts{}, fmt.Errorf("failed to create registry certificate: %w", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return registryCerts{}, fmt.Errorf("failed to encode registry key: %w", err)
+	}
+
+	return registryCerts{
+		caCert: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER}),
+		cert:   pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		key:    pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}, nil
+}
+
+// registryTLSClients holds an *http.Client trusting the registry's CA for
+// each TLS registry host, see registryClient.
+var registryTLSClients sync.Map
+
+// setRegistryCA makes requests to registryHost use HTTPS and trust caCert.
+func setRegistryCA(registryHost string, caCert []byte) error {
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caCert) {
+		return errors.New("failed to parse registry CA certificate")
+	}
+	registryTLSClients.Store(registryHost, &http.Client{
+		Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: &tls.Config{RootCAs: pool},
+		},
+	})
+	return nil
+}
+
+// clearRegistryCA makes requests to registryHost stop trusting the CA set with
+// setRegistryCA, once the registry is deleted or recreated without TLS.
+func clearRegistryCA(registryHost string) {
+	registryTLSClients.Delete(registryHost)
+}
+
+// registryClient returns the URL scheme and client to reach registryHost
+// with: HTTPS with the registry's CA if it was registered with setRegistryCA,
+// plain HTTP for other registries on the host's loopback interface, such as
+// kubicle's plain registries, and HTTPS otherwise, like the Docker daemon.
+func registryClient(registryHost string) (string, *http.Client) {
+	if client, ok := registryTLSClients.Load(registryHost); ok {
+		return "https", client.(*http.Client)
+	}
+	if isLoopbackHost(registryHost) {
+		return "http", http.DefaultClient
+	}
+	return "https", http.DefaultClient
+}
+
+// isLoopbackHost reports whether host, with or without a port, names the
+// loopback interface.
+func isLoopbackHost(host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
@@ -0,0 +1,212 @@
+package kubicle
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// waitPollInterval is how often the Wait* helpers re-check the cluster.
+const waitPollInterval = 500 * time.Millisecond
+
+// podFailureReasons are container waiting reasons that will not resolve on
+// their own, so waiting for the pod to become ready would be pointless.
+var podFailureReasons = map[string]bool{
+	"CrashLoopBackOff":           true,
+	"ImagePullBackOff":           true,
+	"ErrImagePull":               true,
+	"InvalidImageName":           true,
+	"CreateContainerConfigError": true,
+}
+
+// WaitFor calls check every interval, starting right away, until it returns
+// true or an error, or ctx is done. It is a building block for waiting on
+// conditions no other Wait* method covers, e.g. a PVC being bound; use
+// context.WithTimeout to bound the wait. If interval is zero, it defaults to
+// 500 milliseconds.
+func (c *Cluster) WaitFor(ctx context.Context, interval time.Duration, check func(context.Context) (bool, error)) error {
+	if interval == 0 {
+		interval = waitPollInterval
+	}
+
+	err := wait.PollUntilContextCancel(ctx, interval, true, check)
+	if err != nil {
+		return fmt.Errorf("failed waiting for condition: %w", err)
+	}
+	return nil
+}
+
+// WaitForPodReady blocks until the named pod reports the Ready condition or
+// the timeout is reached. It fails early if the pod terminates or one of its
+// containers is stuck in a state such as CrashLoopBackOff or ImagePullBackOff.
+// If timeout is zero, it defaults to 1 minute.
+func (c *Cluster) WaitForPodReady(ctx context.Context, namespace, name string, timeout time.Duration) error {
+	if timeout == 0 {
+		timeout = 1 * time.Minute
+	}
+
+	err := wait.PollUntilContextTimeout(ctx, waitPollInterval, timeout, true, func(ctx context.Context) (bool, error) {
+		pod, err := c.CoreV1().Pods(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			if apierrors.IsNotFound(err) {
+				return false, nil
+			}
+			return false, err
+		}
+
+		switch pod.Status.Phase {
+		case corev1.PodFailed, corev1.PodSucceeded:
+			return false, fmt.Errorf("pod terminated with phase %s", pod.Status.Phase)
+		}
+
+		statuses := append(pod.Status.InitContainerStatuses, pod.Status.ContainerStatuses...)
+		for _, status := range statuses {
+			if waiting := status.State.Waiting; waiting != nil && podFailureReasons[waiting.Reason] {
+				return false, fmt.Errorf("container %s is in %s: %s", status.Name, waiting.Reason, waiting.Message)
+			}
+		}
+
+		for _, condition := range pod.Status.Conditions {
+			if condition.Type == corev1.PodReady {
+				return condition.Status == corev1.ConditionTrue, nil
+			}
+		}
+		return false, nil
+	})
+	if err != nil {
+		// The reason a pod is stuck, e.g. that it cannot be scheduled, is
+		// usually only recorded as an event. ctx may be done already.
+		eventCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+		defer cancel()
+		if event, ok := c.lastWarningEvent(eventCtx, namespace, "Pod", name); ok {
+			return fmt.Errorf("failed waiting for pod %s/%s to be ready: %w (last warning: %s: %s)", namespace, name, err, event.Reason, event.Message)
+		}
+		return fmt.Errorf("failed waiting for pod %s/%s to be ready: %w", namespace, name, err)
+	}
+	return nil
+}
+
+// WaitForDeploymentAvailable blocks until the named deployment has rolled out
+// its current spec and all of its replicas are available, or the timeout is
+// reached. If timeout is zero, it defaults to 1 minute.
+func (c *Cluster) WaitForDeploymentAvailable(ctx context.Context, namespace, name string, timeout time.Duration) error {
+	if timeout == 0 {
+		timeout = 1 * time.Minute
+	}
+
+	err := wait.PollUntilContextTimeout(ctx, waitPollInterval, timeout, true, func(ctx context.Context) (bool, error) {
+		deployment, err := c.AppsV1().Deployments(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			if apierrors.IsNotFound(err) {
+				return false, nil
+			}
+			return false, err
+		}
+		return deploymentAvailable(deployment), nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed waiting for deployment %s/%s to be available: %w", namespace, name, err)
+	}
+	return nil
+}
+
+func deploymentAvailable(d *appsv1.Deployment) bool {
+	if d.Status.ObservedGeneration < d.Generation {
+		return false
+	}
+
+	replicas := int32(1)
+	if d.Spec.Replicas != nil {
+		replicas = *d.Spec.Replicas
+	}
+	if d.Status.UpdatedReplicas != replicas || d.Status.AvailableReplicas != replicas {
+		return false
+	}
+
+	for _, condition := range d.Status.Conditions {
+		if condition.Type == appsv1.DeploymentAvailable {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+func statefulSetReady(s *appsv1.StatefulSet) bool {
+	if s.Status.ObservedGeneration < s.Generation {
+		return false
+	}
+
+	replicas := int32(1)
+	if s.Spec.Replicas != nil {
+		replicas = *s.Spec.Replicas
+	}
+	return s.Status.UpdatedReplicas == replicas && s.Status.ReadyReplicas == replicas
+}
+
+func daemonSetReady(ds *appsv1.DaemonSet) bool {
+	return ds.Status.ObservedGeneration >= ds.Generation &&
+		ds.Status.UpdatedNumberScheduled == ds.Status.DesiredNumberScheduled &&
+		ds.Status.NumberReady == ds.Status.DesiredNumberScheduled
+}
+
+// WaitForNamespaceReady blocks until every Deployment in the namespace is
+// available and every StatefulSet and DaemonSet is ready, e.g. after applying
+// a bundle of manifests with ApplyManifest. The timeout applies to all of
+// them together; if it is reached, the error names the workloads that were
+// not ready yet. If timeout is zero, it defaults to 1 minute.
+func (c *Cluster) WaitForNamespaceReady(ctx context.Context, namespace string, timeout time.Duration) error {
+	if timeout == 0 {
+		timeout = 1 * time.Minute
+	}
+
+	var pending []string
+	err := wait.PollUntilContextTimeout(ctx, waitPollInterval, timeout, true, func(ctx context.Context) (bool, error) {
+		pending = pending[:0]
+
+		deployments, err := c.AppsV1().Deployments(namespace).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return false, err
+		}
+		for i := range deployments.Items {
+			if !deploymentAvailable(&deployments.Items[i]) {
+				pending = append(pending, "deployment/"+deployments.Items[i].Name)
+			}
+		}
+
+		statefulSets, err := c.AppsV1().StatefulSets(namespace).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return false, err
+		}
+		for i := range statefulSets.Items {
+			if !statefulSetReady(&statefulSets.Items[i]) {
+				pending = append(pending, "statefulset/"+statefulSets.Items[i].Name)
+			}
+		}
+
+		daemonSets, err := c.AppsV1().DaemonSets(namespace).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return false, err
+		}
+		for i := range daemonSets.Items {
+			if !daemonSetReady(&daemonSets.Items[i]) {
+				pending = append(pending, "daemonset/"+daemonSets.Items[i].Name)
+			}
+		}
+
+		return len(pending) == 0, nil
+	})
+	if err != nil {
+		if len(pending) > 0 {
+			return fmt.Errorf("failed waiting for namespace %s to be ready: %w (not ready: %s)", namespace, err, strings.Join(pending, ", "))
+		}
+		return fmt.Errorf("failed waiting for namespace %s to be ready: %w", namespace, err)
+	}
+	return nil
+}